@@ -1,7 +1,8 @@
 // Package abdist provides abundance distributions.
 //
 // Each function returns a normalized vector of length n,
-// with nz non-zero values.
+// with nz non-zero values. Functions whose names end with Rand draw
+// randomness from the given source, and the others from the global one.
 package abdist
 
 import (
@@ -9,45 +10,239 @@ import (
 	"math/rand/v2"
 
 	"github.com/fluhus/gostuff/gnum"
+	"github.com/fluhus/izzy/cdf"
 )
 
 const (
 	// STD of the normal distribution in lognormal.
 	// Measured in Project 10K samples.
 	lognormalScale = 1.5
+
+	// Probability of a zero in zero-inflated lognormal, on top of the
+	// n-nz zeros.
+	zeroInflation = 0.3
+
+	// Shape (alpha) of the Pareto distribution. Lower is heavier-tailed.
+	paretoShape = 1.5
+
+	// Exponent of the Zipf rank-abundance curve.
+	zipfExponent = 1.0
+
+	// Shape (k) of the gamma distribution. Lower is more uneven.
+	gammaShape = 0.5
+
+	// Concentration and number of draws of the Dirichlet-multinomial.
+	dirichletAlpha = 0.5
+	dirichletDraws = 100000
 )
 
-// BUG(amit): Add zero-inflated lognormal?
+// A rand.Rand over the global source, for the functions without one.
+var globalRand = rand.New(globalSource{})
+
+// A rand.Source that reads the global source, which is safe for
+// concurrent use.
+type globalSource struct{}
+
+func (globalSource) Uint64() uint64 {
+	return rand.Uint64()
+}
 
 // LogNormal returns lognormal values (exp(normal)).
 func LogNormal(n, nz int) []float64 {
-	return abndnc(n, nz, func() float64 {
-		return math.Exp(rand.NormFloat64() * lognormalScale)
+	return LogNormalRand(n, nz, globalRand)
+}
+
+// LogNormalRand is like LogNormal, drawing randomness from rng.
+func LogNormalRand(n, nz int, rng *rand.Rand) []float64 {
+	return logNormal(n, nz, rng, lognormalScale)
+}
+
+// Returns lognormal values with the given STD of the normal distribution.
+func logNormal(n, nz int, rng *rand.Rand, sigma float64) []float64 {
+	return abndnc(n, nz, rng, func() float64 {
+		return math.Exp(rng.NormFloat64() * sigma)
 	})
 }
 
 // Uniform returns a uniform distribution.
 func Uniform(n, nz int) []float64 {
-	return abndnc(n, nz, func() float64 { return 1 })
+	return UniformRand(n, nz, globalRand)
+}
+
+// UniformRand is like Uniform, drawing randomness from rng.
+func UniformRand(n, nz int, rng *rand.Rand) []float64 {
+	return abndnc(n, nz, rng, func() float64 { return 1 })
 }
 
 // HalfNormal returns half-normal values (abs(normal)).
 func HalfNormal(n, nz int) []float64 {
-	return abndnc(n, nz, func() float64 {
-		return math.Abs(rand.NormFloat64())
+	return HalfNormalRand(n, nz, globalRand)
+}
+
+// HalfNormalRand is like HalfNormal, drawing randomness from rng.
+func HalfNormalRand(n, nz int, rng *rand.Rand) []float64 {
+	return abndnc(n, nz, rng, func() float64 {
+		return math.Abs(rng.NormFloat64())
 	})
 }
 
 // Exponential returns an exponential distribution.
 func Exponential(n, nz int) []float64 {
-	return abndnc(n, nz, rand.ExpFloat64)
+	return ExponentialRand(n, nz, globalRand)
+}
+
+// ExponentialRand is like Exponential, drawing randomness from rng.
+func ExponentialRand(n, nz int, rng *rand.Rand) []float64 {
+	return exponential(n, nz, rng, 1)
+}
+
+// Returns exponential values with the given rate.
+func exponential(n, nz int, rng *rand.Rand, rate float64) []float64 {
+	return abndnc(n, nz, rng, func() float64 {
+		return rng.ExpFloat64() / rate
+	})
+}
+
+// ZeroInflatedLogNormal returns lognormal values, where each non-zero
+// has an additional probability of being zero. At least one value is
+// non-zero.
+func ZeroInflatedLogNormal(n, nz int) []float64 {
+	return ZeroInflatedLogNormalRand(n, nz, globalRand)
+}
+
+// ZeroInflatedLogNormalRand is like ZeroInflatedLogNormal, drawing randomness from rng.
+func ZeroInflatedLogNormalRand(n, nz int, rng *rand.Rand) []float64 {
+	return zeroInflatedLogNormal(n, nz, rng, lognormalScale, zeroInflation)
+}
+
+// Returns zero-inflated lognormal values with the given STD and
+// probability of a zero.
+func zeroInflatedLogNormal(n, nz int, rng *rand.Rand, sigma, zero float64,
+) []float64 {
+	a := make([]float64, n)
+	perm := rng.Perm(n)[:nz]
+	for _, i := range perm {
+		if rng.Float64() >= zero {
+			a[i] = math.Exp(rng.NormFloat64() * sigma)
+		}
+	}
+	if gnum.Sum(a) == 0 && nz > 0 {
+		a[perm[rng.IntN(nz)]] = math.Exp(rng.NormFloat64() * sigma)
+	}
+	gnum.Mul1(a, 1.0/gnum.Sum(a))
+	return a
+}
+
+// Pareto returns power-law values, with a minimum of 1.
+func Pareto(n, nz int) []float64 {
+	return ParetoRand(n, nz, globalRand)
+}
+
+// ParetoRand is like Pareto, drawing randomness from rng.
+func ParetoRand(n, nz int, rng *rand.Rand) []float64 {
+	return pareto(n, nz, rng, paretoShape)
+}
+
+// Returns Pareto values with the given shape.
+func pareto(n, nz int, rng *rand.Rand, alpha float64) []float64 {
+	return abndnc(n, nz, rng, func() float64 {
+		return math.Pow(1-rng.Float64(), -1/alpha)
+	})
+}
+
+// Zipf returns a Zipf rank-abundance curve, where the r'th most abundant
+// value is proportional to 1/r^s. Ranks are assigned randomly.
+func Zipf(n, nz int) []float64 {
+	return ZipfRand(n, nz, globalRand)
+}
+
+// ZipfRand is like Zipf, drawing randomness from rng.
+func ZipfRand(n, nz int, rng *rand.Rand) []float64 {
+	return zipf(n, nz, rng, zipfExponent)
+}
+
+// Returns a Zipf rank-abundance curve with the given exponent.
+func zipf(n, nz int, rng *rand.Rand, s float64) []float64 {
+	rank := 0
+	return abndnc(n, nz, rng, func() float64 {
+		rank++
+		return math.Pow(float64(rank), -s)
+	})
+}
+
+// Gamma returns gamma-distributed values.
+func Gamma(n, nz int) []float64 {
+	return GammaRand(n, nz, globalRand)
+}
+
+// GammaRand is like Gamma, drawing randomness from rng.
+func GammaRand(n, nz int, rng *rand.Rand) []float64 {
+	return gamma(n, nz, rng, gammaShape)
+}
+
+// Returns gamma values with the given shape.
+func gamma(n, nz int, rng *rand.Rand, shape float64) []float64 {
+	return abndnc(n, nz, rng, func() float64 {
+		return gammaVariate(shape, rng)
+	})
+}
+
+// DirichletMultinomial returns the proportions of multinomial draws, with
+// probabilities drawn from a symmetric Dirichlet distribution. Some of the
+// nz values may be zero, like in sequenced samples.
+func DirichletMultinomial(n, nz int) []float64 {
+	return DirichletMultinomialRand(n, nz, globalRand)
+}
+
+// DirichletMultinomialRand is like DirichletMultinomial, drawing randomness from rng.
+func DirichletMultinomialRand(n, nz int, rng *rand.Rand) []float64 {
+	return dirichletMultinomial(n, nz, rng, dirichletAlpha, dirichletDraws)
+}
+
+// Returns Dirichlet-multinomial proportions with the given concentration
+// and number of draws.
+func dirichletMultinomial(n, nz int, rng *rand.Rand, alpha float64,
+	draws int) []float64 {
+	p := abndnc(n, nz, rng, func() float64 {
+		return gammaVariate(alpha, rng)
+	})
+	a := make([]float64, n)
+	alias := cdf.NewAlias(p)
+	for range draws {
+		a[alias.Choose(rng)]++
+	}
+	gnum.Mul1(a, 1.0/float64(draws))
+	return a
+}
+
+// Returns a gamma-distributed value with the given shape and a scale of 1,
+// using the method of Marsaglia and Tsang.
+func gammaVariate(shape float64, rng *rand.Rand) float64 {
+	if shape < 1 {
+		// Boost to shape+1 and scale back.
+		return gammaVariate(shape+1, rng) * math.Pow(rng.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < x*x/2+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
 }
 
 // Returns a normalized vector of size n with nz non-zeros,
 // each non-zero is generated with p.
-func abndnc(n, nz int, p func() float64) []float64 {
+func abndnc(n, nz int, rng *rand.Rand, p func() float64) []float64 {
 	a := make([]float64, n)
-	for _, i := range rand.Perm(n)[:nz] {
+	for _, i := range rng.Perm(n)[:nz] {
 		a[i] = p()
 	}
 	gnum.Mul1(a, 1.0/gnum.Sum(a))
@@ -1,6 +1,8 @@
 package abdist
 
 import (
+	"math"
+	"math/rand/v2"
 	"testing"
 
 	"golang.org/x/exp/maps"
@@ -25,3 +27,96 @@ func TestUniform_nz(t *testing.T) {
 		t.Fatalf("Uniform(5,2)=%v, want %v", got, want)
 	}
 }
+
+func TestLogNormal_seed(t *testing.T) {
+	a := LogNormalRand(20, 10, rand.New(rand.NewPCG(1, 2)))
+	b := LogNormalRand(20, 10, rand.New(rand.NewPCG(1, 2)))
+	if !slices.Equal(a, b) {
+		t.Fatalf("LogNormalRand with same seed: %v != %v", a, b)
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	dists := map[string]func(int, int, *rand.Rand) []float64{
+		"LogNormalRand":             LogNormalRand,
+		"UniformRand":               UniformRand,
+		"HalfNormalRand":            HalfNormalRand,
+		"ExponentialRand":           ExponentialRand,
+		"ZeroInflatedLogNormalRand": ZeroInflatedLogNormalRand,
+		"ParetoRand":                ParetoRand,
+		"ZipfRand":                  ZipfRand,
+		"GammaRand":                 GammaRand,
+		"DirichletMultinomialRand":  DirichletMultinomialRand,
+	}
+	rng := rand.New(rand.NewPCG(0, 0))
+	for name, dist := range dists {
+		for _, nz := range []int{1, 10, 100} {
+			a := dist(100, nz, rng)
+			sum, nonzero := 0.0, 0
+			for _, x := range a {
+				if x < 0 || math.IsNaN(x) {
+					t.Fatalf("%s(100,%d) has bad value: %v", name, nz, x)
+				}
+				if x > 0 {
+					nonzero++
+				}
+				sum += x
+			}
+			if math.Abs(sum-1) > 1e-9 {
+				t.Errorf("%s(100,%d) sums to %v, want 1", name, nz, sum)
+			}
+			if nonzero == 0 || nonzero > nz {
+				t.Errorf("%s(100,%d) has %d non-zeros, want 1-%d",
+					name, nz, nonzero, nz)
+			}
+		}
+	}
+}
+
+func TestZipf(t *testing.T) {
+	got := ZipfRand(4, 4, rand.New(rand.NewPCG(0, 0)))
+	slices.Sort(got)
+	s := 1 + 1.0/2 + 1.0/3 + 1.0/4
+	want := []float64{1.0 / 4 / s, 1.0 / 3 / s, 1.0 / 2 / s, 1 / s}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Fatalf("ZipfRand(4,4)=%v, want %v", got, want)
+		}
+	}
+}
+
+func TestZeroInflatedLogNormal_zeros(t *testing.T) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	zeros := 0
+	for range 100 {
+		for _, x := range ZeroInflatedLogNormalRand(100, 100, rng) {
+			if x == 0 {
+				zeros++
+			}
+		}
+	}
+	// About 5 standard deviations.
+	if zeros < 2770 || zeros > 3230 {
+		t.Errorf("ZeroInflatedLogNormalRand has %d/10000 zeros, want ~3000", zeros)
+	}
+}
+
+func TestGammaVariate(t *testing.T) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	for _, shape := range []float64{0.5, 1, 3} {
+		const n = 100000
+		sum, sum2 := 0.0, 0.0
+		for range n {
+			x := gammaVariate(shape, rng)
+			sum += x
+			sum2 += x * x
+		}
+		mean := sum / n
+		vr := sum2/n - mean*mean
+		// Mean and variance are both the shape.
+		if math.Abs(mean-shape) > 0.02*shape || math.Abs(vr-shape) > 0.05*shape {
+			t.Errorf("gammaVariate(%v): mean=%v var=%v, want %v",
+				shape, mean, vr, shape)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package abdist
+
+import (
+	"math/rand/v2"
+
+	"github.com/fluhus/izzy/spec"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Dist is an abundance distribution with its parameters, parsed from a
+// spec like "lognormal(sigma=2.3)".
+type Dist struct {
+	Name   string
+	Params map[string]float64
+	f      func(n, nz int, rng *rand.Rand, p map[string]float64) []float64
+}
+
+// A distribution that can be selected by name.
+type distSpec struct {
+	params []spec.Param
+	f      func(n, nz int, rng *rand.Rand, p map[string]float64) []float64
+}
+
+// Distributions by name.
+var specs = map[string]distSpec{
+	"lognormal": {
+		[]spec.Param{
+			{Name: "sigma", Value: lognormalScale, Check: spec.Positive},
+		},
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return logNormal(n, nz, rng, p["sigma"])
+		},
+	},
+	"uniform": {
+		nil,
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return UniformRand(n, nz, rng)
+		},
+	},
+	"halfnormal": {
+		nil,
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return HalfNormalRand(n, nz, rng)
+		},
+	},
+	"exponential": {
+		[]spec.Param{
+			{Name: "rate", Value: 1, Check: spec.Positive},
+		},
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return exponential(n, nz, rng, p["rate"])
+		},
+	},
+	"zilognormal": {
+		[]spec.Param{
+			{Name: "sigma", Value: lognormalScale, Check: spec.Positive},
+			{Name: "zero", Value: zeroInflation, Check: spec.Probability},
+		},
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return zeroInflatedLogNormal(n, nz, rng, p["sigma"], p["zero"])
+		},
+	},
+	"pareto": {
+		[]spec.Param{
+			{Name: "alpha", Value: paretoShape, Check: spec.Positive},
+		},
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return pareto(n, nz, rng, p["alpha"])
+		},
+	},
+	"zipf": {
+		[]spec.Param{
+			{Name: "s", Value: zipfExponent, Check: spec.NonNegative},
+		},
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return zipf(n, nz, rng, p["s"])
+		},
+	},
+	"gamma": {
+		[]spec.Param{
+			{Name: "shape", Value: gammaShape, Check: spec.Positive},
+		},
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return gamma(n, nz, rng, p["shape"])
+		},
+	},
+	"dirmult": {
+		[]spec.Param{
+			{Name: "alpha", Value: dirichletAlpha, Check: spec.Positive},
+			{Name: "draws", Value: dirichletDraws, Check: spec.PositiveInt},
+		},
+		func(n, nz int, rng *rand.Rand, p map[string]float64) []float64 {
+			return dirichletMultinomial(n, nz, rng, p["alpha"], int(p["draws"]))
+		},
+	},
+}
+
+// Parse returns the distribution described by spec, which is a name
+// optionally followed by parameters in parentheses, like
+// "lognormal(sigma=2.3)". Missing parameters get their default values.
+func Parse(s string) (*Dist, error) {
+	params := map[string][]spec.Param{}
+	for name, ds := range specs {
+		params[name] = ds.params
+	}
+	name, values, err := spec.Parse(s, "distribution", params)
+	if err != nil {
+		return nil, err
+	}
+	return &Dist{Name: name, Params: values, f: specs[name].f}, nil
+}
+
+// Abundances returns a normalized vector of length n, with nz non-zero
+// values, drawing randomness from rng.
+func (d *Dist) Abundances(n, nz int, rng *rand.Rand) []float64 {
+	return d.f(n, nz, rng, d.Params)
+}
+
+// String returns the distribution's spec with all of its parameters.
+func (d *Dist) String() string {
+	return spec.Format(d.Name, specs[d.Name].params, d.Params)
+}
+
+// Names returns the names of the available distributions, sorted.
+func Names() []string {
+	names := maps.Keys(specs)
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,79 @@
+package abdist
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"lognormal", "lognormal(sigma=1.5)"},
+		{"lognormal(sigma=2.3)", "lognormal(sigma=2.3)"},
+		{" lognormal ( sigma = 2.3 ) ", "lognormal(sigma=2.3)"},
+		{"lognormal()", "lognormal(sigma=1.5)"},
+		{"uniform", "uniform"},
+		{"exponential(rate=0.5)", "exponential(rate=0.5)"},
+		{"zilognormal(zero=0.5)", "zilognormal(sigma=1.5,zero=0.5)"},
+		{"dirmult(draws=1000,alpha=2)", "dirmult(alpha=2,draws=1000)"},
+	}
+	for _, test := range tests {
+		d, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.spec, err)
+		}
+		if got := d.String(); got != test.want {
+			t.Errorf("Parse(%q)=%q, want %q", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestParse_bad(t *testing.T) {
+	for _, spec := range []string{
+		"", "foo", "lognormal(", "lognormal(sigma)", "lognormal(mu=1)",
+		"lognormal(sigma=0)", "lognormal(sigma=x)", "lognormal(sigma=NaN)",
+		"lognormal(sigma=1,sigma=2)", "lognormal(sigma=1,)",
+		"zilognormal(zero=1)", "dirmult(draws=1.5)", "uniform(x=1)",
+	} {
+		if d, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q)=%v, want error", spec, d)
+		}
+	}
+}
+
+// Checks that default specs give the same values as the functions.
+func TestParse_defaults(t *testing.T) {
+	funcs := map[string]func(int, int, *rand.Rand) []float64{
+		"lognormal":   LogNormalRand,
+		"uniform":     UniformRand,
+		"halfnormal":  HalfNormalRand,
+		"exponential": ExponentialRand,
+		"zilognormal": ZeroInflatedLogNormalRand,
+		"pareto":      ParetoRand,
+		"zipf":        ZipfRand,
+		"gamma":       GammaRand,
+		"dirmult":     DirichletMultinomialRand,
+	}
+	want := maps.Keys(funcs)
+	slices.Sort(want)
+	if got := Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Names()=%v, want %v", got, want)
+	}
+	for name, f := range funcs {
+		d, err := Parse(name)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", name, err)
+		}
+		got := d.Abundances(20, 10, rand.New(rand.NewPCG(1, 2)))
+		want := f(20, 10, rand.New(rand.NewPCG(1, 2)))
+		if !slices.Equal(got, want) {
+			t.Errorf("Parse(%q).Abundances(...)=%v, want %v", name, got, want)
+		}
+	}
+}
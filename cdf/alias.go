@@ -0,0 +1,89 @@
+package cdf
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// Alias is a discrete sampler that draws in constant time,
+// using Vose's alias method.
+type Alias struct {
+	prob  []float64 // Probability of keeping each bucket's own index
+	alias []int     // Index to return when not keeping
+}
+
+// NewAlias returns an alias sampler for the given non-negative weights.
+// Weights do not need to be normalized. Panics if weights is empty,
+// contains negative values or sums to zero.
+func NewAlias(weights []float64) *Alias {
+	if len(weights) == 0 {
+		panic("got empty weights")
+	}
+	sum := 0.0
+	for i, w := range weights {
+		if w < 0 {
+			panic(fmt.Sprintf("weights[%d]=%f, want >=0", i, w))
+		}
+		sum += w
+	}
+	if sum <= 0 {
+		panic(fmt.Sprintf("weights sum to %f, want >0", sum))
+	}
+
+	n := len(weights)
+	a := &Alias{make([]float64, n), make([]int, n)}
+	scaled := make([]float64, n)
+	var small, large []int
+	for i, w := range weights {
+		scaled[i] = w * float64(n) / sum
+		if scaled[i] < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		s, l := small[len(small)-1], large[len(large)-1]
+		small = small[:len(small)-1]
+		a.prob[s] = scaled[s]
+		a.alias[s] = l
+		scaled[l] -= 1 - scaled[s]
+		if scaled[l] < 1 {
+			large = large[:len(large)-1]
+			small = append(small, l)
+		}
+	}
+	// Leftovers are 1 up to rounding errors.
+	for _, i := range large {
+		a.prob[i] = 1
+		a.alias[i] = i
+	}
+	for _, i := range small {
+		a.prob[i] = 1
+		a.alias[i] = i
+	}
+	return a
+}
+
+// Alias returns an alias sampler with the same distribution as c.
+// Panics if c is empty or decreasing.
+func (c CDF) Alias() *Alias {
+	w := make([]float64, len(c))
+	prev := 0.0
+	for i, x := range c {
+		w[i] = x - prev
+		prev = x
+	}
+	return NewAlias(w)
+}
+
+// Choose picks an element according to the distribution.
+func (a *Alias) Choose(rng *rand.Rand) int {
+	// A single draw gives both the bucket and the coin toss.
+	u := rng.Float64() * float64(len(a.prob))
+	i := int(u)
+	if u-float64(i) < a.prob[i] {
+		return i
+	}
+	return a.alias[i]
+}
@@ -0,0 +1,49 @@
+package cdf
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestAlias_oneValue(t *testing.T) {
+	tests := []struct {
+		cdf  CDF
+		want int
+	}{
+		{[]float64{1}, 0},
+		{[]float64{0, 1}, 1},
+		{[]float64{1, 1}, 0},
+		{[]float64{0, 0, 1}, 2},
+		{[]float64{0, 1, 1}, 1},
+		{[]float64{1, 1, 1}, 0},
+	}
+	rng := rand.New(rand.NewPCG(0, 0))
+	for _, test := range tests {
+		a := test.cdf.Alias()
+		for range 100 {
+			if got := a.Choose(rng); got != test.want {
+				t.Fatalf("Alias(%v).Choose()=%d, want %d",
+					test.cdf, got, test.want)
+			}
+		}
+	}
+}
+
+func TestAlias_dist(t *testing.T) {
+	const n = 1000000
+	weights := []float64{1, 0, 3, 2, 0, 4}
+	a := NewAlias(weights)
+	rng := rand.New(rand.NewPCG(0, 0))
+	counts := make([]float64, len(weights))
+	for range n {
+		counts[a.Choose(rng)]++
+	}
+	for i, w := range weights {
+		want := w / 10
+		got := counts[i] / n
+		if math.Abs(got-want) > 0.002 {
+			t.Errorf("frequency of %d=%f, want %f", i, got, want)
+		}
+	}
+}
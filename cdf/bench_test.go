@@ -0,0 +1,33 @@
+package cdf_test
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/fluhus/izzy/cdf"
+	"github.com/fluhus/izzy/model"
+)
+
+// Draws from the quality CDFs of the NovaSeq model, position by position.
+func BenchmarkChoose(b *testing.B) {
+	var cdfs []cdf.CDF
+	for _, hist := range model.NovaSeqModel.QualityHistForward {
+		cdfs = append(cdfs, hist...)
+	}
+	rng := rand.New(rand.NewPCG(0, 0))
+	b.Run("CDF", func(b *testing.B) {
+		for i := range b.N {
+			cdfs[i%len(cdfs)].Choose(rng)
+		}
+	})
+	b.Run("Alias", func(b *testing.B) {
+		aliases := make([]*cdf.Alias, len(cdfs))
+		for i, c := range cdfs {
+			aliases[i] = c.Alias()
+		}
+		b.ResetTimer()
+		for i := range b.N {
+			aliases[i%len(aliases)].Choose(rng)
+		}
+	})
+}
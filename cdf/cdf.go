@@ -14,21 +14,30 @@ type CDF []float64
 // Check checks that a CDF is non-empty, non-decreasing,
 // and ends in 1. Panics if not.
 func (c CDF) Check() {
-	if len(c) == 0 {
-		panic("got empty cdf")
+	if err := c.Validate(); err != nil {
+		panic(err.Error())
 	}
-	if c[len(c)-1] != 1 {
-		panic(fmt.Sprintf("last element is %f, want 1", c[len(c)-1]))
+}
+
+// Validate checks that a CDF is non-empty, non-decreasing,
+// and ends in 1. Returns an error if not.
+func (c CDF) Validate() error {
+	if len(c) == 0 {
+		return fmt.Errorf("got empty cdf")
 	}
 	for i := range c {
-		if c[i] < 0 {
-			panic(fmt.Sprintf("cdf[%d]=%f, want >=0", i, c[len(c)-1]))
+		if !(c[i] >= 0) { // Also catches NaN.
+			return fmt.Errorf("cdf[%d]=%f, want >=0", i, c[i])
 		}
 		if i > 0 && c[i-1] > c[i] {
-			panic(fmt.Sprintf("cdf[%d]>cdf[%d]: %f>%f",
-				i-1, i, c[i-1], c[i]))
+			return fmt.Errorf("cdf[%d]>cdf[%d]: %f>%f",
+				i-1, i, c[i-1], c[i])
 		}
 	}
+	if c[len(c)-1] != 1 {
+		return fmt.Errorf("last element is %f, want 1", c[len(c)-1])
+	}
+	return nil
 }
 
 // Choose picks an element from the CDF according to the distribution.
@@ -1,6 +1,7 @@
 package cdf
 
 import (
+	"math"
 	"math/rand/v2"
 	"testing"
 )
@@ -27,3 +28,24 @@ func TestChoose_oneValue(t *testing.T) {
 		}
 	}
 }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		cdf  CDF
+		want bool
+	}{
+		{[]float64{1}, true},
+		{[]float64{0, 0.5, 1}, true},
+		{[]float64{}, false},
+		{[]float64{0.5}, false},
+		{[]float64{0.5, 0.4, 1}, false},
+		{[]float64{-0.1, 1}, false},
+		{[]float64{math.NaN(), 1}, false},
+		{[]float64{0.5, 1, 1.5}, false},
+	}
+	for _, test := range tests {
+		if err := test.cdf.Validate(); (err == nil) != test.want {
+			t.Errorf("Validate(%v)=%v, want ok=%v", test.cdf, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/gostuff/iterx"
+)
+
+// Reading of abundance files in various formats.
+
+// Formats of abundance files.
+const (
+	abndAuto      = "auto"      // Detect from the contents
+	abndRelative  = "relative"  // Name and relative abundance or percentage
+	abndCounts    = "counts"    // Name and number of reads
+	abndCoverage  = "coverage"  // Name and coverage
+	abndCAMI      = "cami"      // CAMI taxonomic profile
+	abndMetaPhlAn = "metaphlan" // MetaPhlAn profile
+	abndBracken   = "bracken"   // Bracken abundance table
+	abndKreport   = "kreport"   // Kraken-style report, as written by Bracken
+)
+
+// Abundance file formats that come from profilers, which may have taxa that
+// are not among the input genomes.
+var profileFormats = map[string]bool{
+	abndCAMI: true, abndMetaPhlAn: true, abndBracken: true, abndKreport: true,
+}
+
+// Kraken report rank codes, from general to specific.
+const kreportRanks = "RDKPCOFGS"
+
+// An abundance value from a file.
+type abndEntry struct {
+	names []string // Candidate group names, in order of preference
+	value float64
+}
+
+// Reads an abundance file and returns the fraction of reads of each group.
+// Relative abundances are weighted by genome length, unless ignoring
+// lengths, while Bracken and Kraken report values are read fractions as they
+// are. For read counts and coverage, also returns the number of reads of
+// each group, otherwise nil. The returned bool tells whether the fractions
+// were weighted by length, so that dividing by length gives the file's
+// values back.
+func readAbundanceFile(file, format string, groupLens map[string]int,
+	readLen int) (map[string]float64, map[string]float64, bool, error) {
+	var lines []string
+	for line, err := range iterx.LinesFile(file) {
+		if err != nil {
+			return nil, nil, false, err
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+	}
+	if format == abndAuto {
+		format = detectAbundanceFormat(lines)
+		fmt.Println("Abundance format:", format)
+	}
+	entries, err := parseAbundance(lines, format)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	// Match entries to groups.
+	values := map[string]float64{}
+	skipped, skippedValue, total := 0, 0.0, 0.0
+	for _, e := range entries {
+		total += e.value
+		i := slices.IndexFunc(e.names, func(name string) bool {
+			_, ok := groupLens[name]
+			return ok
+		})
+		if i == -1 {
+			if !profileFormats[format] {
+				return nil, nil, false, fmt.Errorf("unrecognized name: %s",
+					e.names[0])
+			}
+			skipped++
+			skippedValue += e.value
+			continue
+		}
+		name := e.names[i]
+		if _, ok := values[name]; ok && !profileFormats[format] {
+			return nil, nil, false, fmt.Errorf(
+				"duplicate name: %s, values: %v %v", name, values[name], e.value)
+		}
+		values[name] += e.value
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipped %d entries that match no group "+
+			"(%.1f%% of the abundance)\n", skipped, skippedValue/total*100)
+	}
+	if len(values) == 0 {
+		return nil, nil, false, fmt.Errorf("no abundances in file")
+	}
+
+	var reads map[string]float64
+	if format == abndCounts || format == abndCoverage {
+		reads = map[string]float64{}
+	}
+	weighted := false
+	for g, v := range values {
+		switch format {
+		case abndCounts:
+			reads[g] = v
+		case abndCoverage:
+			values[g] = v * float64(groupLens[g]) / float64(readLen)
+			reads[g] = values[g]
+			weighted = true
+		case abndBracken, abndKreport:
+			// Already proportional to reads.
+		default:
+			if !*ignoreLength {
+				values[g] *= float64(groupLens[g])
+				weighted = true
+			}
+		}
+	}
+	sum := sortedSum(values)
+	for k := range values {
+		values[k] /= sum
+	}
+	return values, reads, weighted, nil
+}
+
+// Returns the format of an abundance file from its lines.
+func detectAbundanceFormat(lines []string) string {
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@SampleID"),
+			strings.HasPrefix(line, "@Version"),
+			strings.HasPrefix(line, "@Ranks"),
+			strings.HasPrefix(line, "@@TAXID"):
+			return abndCAMI
+		case strings.HasPrefix(line, "#") &&
+			(strings.Contains(line, "clade_name") ||
+				strings.HasPrefix(line, "#mpa_")):
+			return abndMetaPhlAn
+		}
+	}
+	for _, line := range lines {
+		if isAbundanceComment(line) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		switch {
+		case len(fields) >= 7 && fields[0] == "name" &&
+			fields[1] == "taxonomy_id":
+			return abndBracken
+		case len(fields) == 6 && isNumber(fields[0]) &&
+			isKreportRank(fields[3]):
+			return abndKreport
+		case strings.HasPrefix(fields[0], "k__") ||
+			strings.HasPrefix(fields[0], "d__"):
+			return abndMetaPhlAn
+		case len(fields) == 2 && !isNumber(fields[1]):
+			// A header, which may name the values.
+			h := strings.ToLower(fields[1])
+			switch {
+			case strings.Contains(h, "read") || strings.Contains(h, "count"):
+				return abndCounts
+			case strings.Contains(h, "cov") || strings.Contains(h, "depth"):
+				return abndCoverage
+			}
+		}
+		break
+	}
+	return abndRelative
+}
+
+// Parses abundance entries from the lines of a file in the given format.
+func parseAbundance(lines []string, format string) ([]abndEntry, error) {
+	switch format {
+	case abndRelative, abndCounts, abndCoverage:
+		return parseTwoColumns(lines)
+	case abndCAMI:
+		return parseCAMI(lines)
+	case abndMetaPhlAn:
+		return parseMetaPhlAn(lines)
+	case abndBracken:
+		return parseBracken(lines)
+	case abndKreport:
+		return parseKreport(lines)
+	}
+	return nil, fmt.Errorf("bad abundance format: %q", format)
+}
+
+// Parses lines of a name and a value, with an optional header line.
+// Values may be percentages, with a % suffix.
+func parseTwoColumns(lines []string) ([]abndEntry, error) {
+	var result []abndEntry
+	first := true
+	for i, line := range lines {
+		if isAbundanceComment(line) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: found %d fields, want 2",
+				i+1, len(fields))
+		}
+		s := strings.TrimSuffix(strings.TrimSpace(fields[1]), "%")
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil && first { // Header.
+			first = false
+			continue
+		}
+		first = false
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if x <= 0 {
+			return nil, fmt.Errorf("bad abundance value: %v", x)
+		}
+		result = append(result, abndEntry{[]string{fields[0]}, x})
+	}
+	return result, nil
+}
+
+// Parses the first sample of a CAMI profile, using its most specific
+// entries.
+func parseCAMI(lines []string) ([]abndEntry, error) {
+	cols := map[string]int{"TAXID": 0, "RANK": 1, "TAXPATH": 2,
+		"TAXPATHSN": 3, "PERCENTAGE": 4}
+	var paths [][]string
+	var rows [][]string
+	samples := 0
+	for i, line := range lines {
+		if strings.HasPrefix(line, "@@") {
+			for j, name := range strings.Split(line[2:], "\t") {
+				cols[strings.ToUpper(strings.TrimSpace(name))] = j
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "@SampleID") {
+			if samples++; samples > 1 {
+				break
+			}
+		}
+		if strings.HasPrefix(line, "@") || isAbundanceComment(line) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) <= cols["PERCENTAGE"] {
+			return nil, fmt.Errorf("line %d: found %d fields, want at least %d",
+				i+1, len(fields), cols["PERCENTAGE"]+1)
+		}
+		rows = append(rows, fields)
+		paths = append(paths, strings.Split(fields[cols["TAXPATH"]], "|"))
+	}
+
+	var result []abndEntry
+	for _, i := range leaves(paths) {
+		fields := rows[i]
+		x, err := parsePercentage(fields[cols["PERCENTAGE"]])
+		if err != nil {
+			return nil, err
+		}
+		if x == 0 {
+			continue
+		}
+		var names []string
+		if j, ok := cols["_CAMI_GENOMEID"]; ok && j < len(fields) &&
+			fields[j] != "" {
+			names = append(names, fields[j])
+		}
+		names = append(names, fields[cols["TAXID"]])
+		sn := strings.Split(fields[cols["TAXPATHSN"]], "|")
+		names = append(names, sn[len(sn)-1])
+		result = append(result, abndEntry{names, x})
+	}
+	return result, nil
+}
+
+// Parses a MetaPhlAn profile, using its most specific clades.
+func parseMetaPhlAn(lines []string) ([]abndEntry, error) {
+	taxCol, abCol := -1, -1
+	var paths [][]string
+	var rows [][]string
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#clade_name") {
+			for j, name := range strings.Split(line[1:], "\t") {
+				switch name {
+				case "NCBI_tax_id", "clade_taxid":
+					taxCol = j
+				case "relative_abundance":
+					abCol = j
+				}
+			}
+			continue
+		}
+		if isAbundanceComment(line) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: found %d fields, want at least 2",
+				i+1, len(fields))
+		}
+		rows = append(rows, fields)
+		paths = append(paths, strings.Split(fields[0], "|"))
+	}
+	if abCol == -1 && len(rows) > 0 { // No header, guess by version.
+		abCol, taxCol = 1, -1
+		if len(rows[0]) >= 3 {
+			abCol, taxCol = 2, 1
+		}
+	}
+
+	var result []abndEntry
+	for _, i := range leaves(paths) {
+		fields := rows[i]
+		if abCol >= len(fields) {
+			return nil, fmt.Errorf("clade %s: missing abundance", fields[0])
+		}
+		x, err := parsePercentage(fields[abCol])
+		if err != nil {
+			return nil, err
+		}
+		if x == 0 {
+			continue
+		}
+		last := paths[i][len(paths[i])-1]
+		names := []string{last}
+		if len(last) > 3 && last[1:3] == "__" {
+			names = []string{last[3:], last}
+		}
+		if taxCol != -1 && taxCol < len(fields) {
+			taxids := strings.Split(fields[taxCol], "|")
+			names = append(names, taxids[len(taxids)-1])
+		}
+		result = append(result, abndEntry{names, x})
+	}
+	return result, nil
+}
+
+// Parses a Bracken abundance table.
+func parseBracken(lines []string) ([]abndEntry, error) {
+	cols := map[string]int{}
+	var result []abndEntry
+	for i, line := range lines {
+		if isAbundanceComment(line) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(cols) == 0 { // Header.
+			for j, name := range fields {
+				cols[name] = j
+			}
+			if _, ok := cols["fraction_total_reads"]; !ok {
+				return nil, fmt.Errorf("no fraction_total_reads column")
+			}
+			continue
+		}
+		if len(fields) != len(cols) {
+			return nil, fmt.Errorf("line %d: found %d fields, want %d",
+				i+1, len(fields), len(cols))
+		}
+		x, err := parsePercentage(fields[cols["fraction_total_reads"]])
+		if err != nil {
+			return nil, err
+		}
+		if x == 0 {
+			continue
+		}
+		names := []string{fields[cols["name"]]}
+		if j, ok := cols["taxonomy_id"]; ok {
+			names = append(names, fields[j])
+		}
+		result = append(result, abndEntry{names, x})
+	}
+	return result, nil
+}
+
+// Parses a Kraken-style report, using the reads of its most specific
+// standard rank.
+func parseKreport(lines []string) ([]abndEntry, error) {
+	var rows [][]string
+	rank := -1
+	for i, line := range lines {
+		if isAbundanceComment(line) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("line %d: found %d fields, want 6",
+				i+1, len(fields))
+		}
+		rows = append(rows, fields)
+		if r := fields[3]; len(r) == 1 {
+			rank = max(rank, strings.Index(kreportRanks, r))
+		}
+	}
+	if rank <= 0 {
+		return nil, fmt.Errorf("no taxa of a standard rank")
+	}
+
+	var result []abndEntry
+	for _, fields := range rows {
+		if fields[3] != kreportRanks[rank:rank+1] {
+			continue
+		}
+		x, err := parsePercentage(fields[1]) // Clade reads.
+		if err != nil {
+			return nil, err
+		}
+		if x == 0 {
+			continue
+		}
+		result = append(result, abndEntry{
+			[]string{strings.TrimSpace(fields[5]), fields[4]}, x})
+	}
+	return result, nil
+}
+
+// Returns the indexes of the paths that are not a prefix of another path.
+func leaves(paths [][]string) []int {
+	inner := map[string]bool{}
+	for _, p := range paths {
+		for i := 1; i < len(p); i++ {
+			inner[strings.Join(p[:i], "|")] = true
+		}
+	}
+	var result []int
+	for i, p := range paths {
+		if !inner[strings.Join(p, "|")] {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// Parses a non-negative value, possibly with a % suffix.
+func parsePercentage(s string) (float64, error) {
+	x, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"),
+		64)
+	if err != nil {
+		return 0, err
+	}
+	if x < 0 {
+		return 0, fmt.Errorf("bad abundance value: %v", x)
+	}
+	return x, nil
+}
+
+// Checks whether an abundance file line should be ignored.
+func isAbundanceComment(line string) bool {
+	return strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#")
+}
+
+// Checks whether s is a number.
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil
+}
+
+// Checks whether s is a Kraken report rank code, like S or S1.
+func isKreportRank(s string) bool {
+	return len(s) > 0 && strings.Contains(kreportRanks+"U", s[:1]) &&
+		(len(s) == 1 || isNumber(s[1:]))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	camiProfile = `# Taxonomic profile
+@SampleID:s1
+@Version:0.9.1
+@Ranks:superkingdom|phylum|species|strain
+@@TAXID	RANK	TAXPATH	TAXPATHSN	PERCENTAGE	_CAMI_GENOMEID
+2	superkingdom	2	Bacteria	100
+1224	phylum	2|1224	Bacteria|Proteobacteria	100
+562	species	2|1224|562	Bacteria|Proteobacteria|Escherichia coli	75
+28901	species	2|1224|28901	Bacteria|Proteobacteria|Salmonella enterica	25
+83333	strain	2|1224|562|83333	Bacteria|Proteobacteria|Escherichia coli|K-12	75	genome1
+@SampleID:s2
+@@TAXID	RANK	TAXPATH	TAXPATHSN	PERCENTAGE
+2	superkingdom	2	Bacteria	100
+`
+	metaphlanProfile = `#mpa_vJan21_CHOCOPhlAnSGB_202103
+#clade_name	NCBI_tax_id	relative_abundance	additional_species
+k__Bacteria	2	100.0
+k__Bacteria|p__Bacteroidetes	2|976	60.0
+k__Bacteria|p__Bacteroidetes|s__Bacteroides_dorei	2|976|357276	60.0
+k__Bacteria|p__Firmicutes	2|1239	40.0
+k__Bacteria|p__Firmicutes|s__Ruminococcus_bromii	2|1239|40518	40.0
+`
+	brackenTable = `name	taxonomy_id	taxonomy_lvl	kraken_assigned_reads	added_reads	new_est_reads	fraction_total_reads
+Escherichia coli	562	S	100	50	150	0.75
+Salmonella enterica	28901	S	40	10	50	0.25
+`
+	kreport = `  5.00	10	10	U	0	unclassified
+ 95.00	190	0	R	1	root
+ 95.00	190	0	D	2	  Bacteria
+ 75.00	150	150	S	562	    Escherichia coli
+ 20.00	40	40	S	28901	    Salmonella enterica
+`
+)
+
+func TestDetectAbundanceFormat(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"g1\t0.5\ng2\t0.5\n", abndRelative},
+		{"# comment\ngenome\tpercent\ng1\t50%\n", abndRelative},
+		{"genome\treads\ng1\t100\n", abndCounts},
+		{"genome\tcoverage\ng1\t10\n", abndCoverage},
+		{camiProfile, abndCAMI},
+		{metaphlanProfile, abndMetaPhlAn},
+		{"k__Bacteria\t100.0\n", abndMetaPhlAn},
+		{brackenTable, abndBracken},
+		{kreport, abndKreport},
+	}
+	for _, test := range tests {
+		lines := strings.Split(test.data, "\n")
+		if got := detectAbundanceFormat(lines); got != test.want {
+			t.Errorf("detectAbundanceFormat(%q)=%q, want %q",
+				test.data, got, test.want)
+		}
+	}
+}
+
+func TestParseAbundance(t *testing.T) {
+	tests := []struct {
+		data   string
+		format string
+		want   []abndEntry
+	}{
+		{"name\tvalue\ng1\t30%\n# comment\n\ng2\t70\n", abndRelative,
+			[]abndEntry{{[]string{"g1"}, 30}, {[]string{"g2"}, 70}}},
+		{camiProfile, abndCAMI, []abndEntry{
+			{[]string{"28901", "Salmonella enterica"}, 25},
+			{[]string{"genome1", "83333", "K-12"}, 75},
+		}},
+		{metaphlanProfile, abndMetaPhlAn, []abndEntry{
+			{[]string{"Bacteroides_dorei", "s__Bacteroides_dorei", "357276"}, 60},
+			{[]string{"Ruminococcus_bromii", "s__Ruminococcus_bromii", "40518"}, 40},
+		}},
+		{"k__Bacteria\t100\nk__Bacteria|s__Escherichia_coli\t100\n",
+			abndMetaPhlAn, []abndEntry{
+				{[]string{"Escherichia_coli", "s__Escherichia_coli"}, 100},
+			}},
+		{brackenTable, abndBracken, []abndEntry{
+			{[]string{"Escherichia coli", "562"}, 0.75},
+			{[]string{"Salmonella enterica", "28901"}, 0.25},
+		}},
+		{kreport, abndKreport, []abndEntry{
+			{[]string{"Escherichia coli", "562"}, 150},
+			{[]string{"Salmonella enterica", "28901"}, 40},
+		}},
+	}
+	for _, test := range tests {
+		got, err := parseAbundance(strings.Split(test.data, "\n"), test.format)
+		if err != nil {
+			t.Fatalf("parseAbundance(%q,%q) failed: %v",
+				test.data, test.format, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseAbundance(%q,%q)=%v, want %v",
+				test.data, test.format, got, test.want)
+		}
+	}
+}
+
+func TestReadAbundanceFile(t *testing.T) {
+	defer func(l bool) { *ignoreLength = l }(*ignoreLength)
+	*ignoreLength = false
+	groupLens := map[string]int{"g1": 1000, "g2": 3000, "562": 2000,
+		"28901": 6000}
+	tests := []struct {
+		data      string
+		format    string
+		want      map[string]float64
+		wantReads map[string]float64
+		wantW     bool
+	}{
+		{"g1\t3\ng2\t1\n", abndAuto,
+			map[string]float64{"g1": 0.5, "g2": 0.5}, nil, true},
+		{"genome\treads\ng1\t300\ng2\t100\n", abndAuto,
+			map[string]float64{"g1": 0.75, "g2": 0.25},
+			map[string]float64{"g1": 300, "g2": 100}, false},
+		{"g1\t10\ng2\t10\n", abndCoverage,
+			map[string]float64{"g1": 0.25, "g2": 0.75},
+			map[string]float64{"g1": 100, "g2": 300}, true},
+		// Read fractions, not weighted by length.
+		{brackenTable, abndAuto,
+			map[string]float64{"562": 0.75, "28901": 0.25}, nil, false},
+		{kreport, abndAuto,
+			map[string]float64{"562": 150.0 / 190, "28901": 40.0 / 190},
+			nil, false},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "abnd.tsv")
+		if err := os.WriteFile(file, []byte(test.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, reads, w, err := readAbundanceFile(file, test.format, groupLens,
+			100)
+		if err != nil {
+			t.Fatalf("readAbundanceFile(%q) failed: %v", test.data, err)
+		}
+		if !reflect.DeepEqual(reads, test.wantReads) || w != test.wantW ||
+			len(got) != len(test.want) {
+			t.Fatalf("readAbundanceFile(%q)=%v,%v,%v, want %v,%v,%v",
+				test.data, got, reads, w, test.want, test.wantReads, test.wantW)
+		}
+		for k, v := range test.want {
+			if math.Abs(got[k]-v) > 1e-9 {
+				t.Fatalf("readAbundanceFile(%q)=%v,%v, want %v,%v",
+					test.data, got, reads, test.want, test.wantReads)
+			}
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "abnd.tsv")
+	for _, data := range []string{"g3\t1\n", "g1\t1\ng1\t2\n", "g1\t0\n", ""} {
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _, err := readAbundanceFile(file, abndAuto, groupLens,
+			100); err == nil {
+			t.Errorf("readAbundanceFile(%q)=%v, want error", data, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/fluhus/gostuff/snm"
+	"github.com/fluhus/izzy/cdf"
+	"golang.org/x/exp/maps"
+)
+
+// Allocation of fragments to the input sequences.
+
+// Modes of allocating fragments to sequences.
+const (
+	allocRound = "round" // Randomly round each sequence's expected count
+	allocExact = "exact" // Draw exactly the requested total
+)
+
+// Maximal number of excluded sequences to list in the warning.
+const maxExcludedListed = 20
+
+// Returns the number of fragments to simulate from each sequence, drawn
+// from a multinomial distribution so that they sum to n. Sequences shorter
+// than minLen are excluded and their share goes to the other sequences of
+// their group, or to the other groups if none is left. Also returns the
+// indexes of the excluded sequences.
+func multinomialCounts(lens []lenGroup, groupRatios map[string]float64,
+	n, minLen int, rng *rand.Rand) ([]int, []int, error) {
+	eligibleLens := map[string]int{}
+	var excluded []int
+	for i, gl := range lens {
+		if gl.n < minLen {
+			excluded = append(excluded, i)
+			continue
+		}
+		eligibleLens[gl.g] += gl.n
+	}
+
+	weights := make([]float64, len(lens))
+	total := 0.0
+	for i, gl := range lens {
+		if gl.n < minLen {
+			continue
+		}
+		weights[i] = groupRatios[gl.g] * float64(gl.n) /
+			float64(eligibleLens[gl.g])
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("no sequences of at least %d bases "+
+			"to simulate from", minLen)
+	}
+
+	counts := make([]int, len(lens))
+	alias := cdf.NewAlias(weights)
+	for range n {
+		counts[alias.Choose(rng)]++
+	}
+	return counts, excluded, nil
+}
+
+// Returns the number of fragments to simulate from each sequence, given the
+// number of reads of each group. Each group's fragments are split between
+// its sequences by length, keeping the group's total. Sequences shorter than
+// minLen are excluded, and their indexes are returned too.
+func groupCounts(lens []lenGroup, groupReads map[string]float64,
+	minLen int) ([]int, []int, error) {
+	eligible := map[string][]int{} // Indexes of each group's sequences.
+	eligibleLens := map[string]int{}
+	var excluded []int
+	for i, gl := range lens {
+		if gl.n < minLen {
+			excluded = append(excluded, i)
+			continue
+		}
+		eligible[gl.g] = append(eligible[gl.g], i)
+		eligibleLens[gl.g] += gl.n
+	}
+
+	counts := make([]int, len(lens))
+	for _, g := range snm.Sorted(maps.Keys(groupReads)) {
+		n := int(math.Round(groupReads[g] / float64(readsPerFragment())))
+		if n == 0 {
+			continue
+		}
+		if len(eligible[g]) == 0 {
+			return nil, nil, fmt.Errorf("group %s has reads but no sequences "+
+				"of at least %d bases", g, minLen)
+		}
+		// Largest remainder rounding.
+		left := n
+		rem := make([]float64, len(lens))
+		for _, i := range eligible[g] {
+			x := float64(n) * float64(lens[i].n) / float64(eligibleLens[g])
+			counts[i] = int(x)
+			rem[i] = x - float64(counts[i])
+			left -= counts[i]
+		}
+		order := slices.Clone(eligible[g])
+		slices.SortStableFunc(order, func(a, b int) int {
+			return cmp.Compare(rem[b], rem[a])
+		})
+		for _, i := range order[:left] {
+			counts[i]++
+		}
+	}
+	return counts, excluded, nil
+}
+
+// Returns a warning about the given excluded sequences.
+func excludedWarning(lens []lenGroup, excluded []int, minLen int) string {
+	var names []string
+	for _, i := range excluded[:min(len(excluded), maxExcludedListed)] {
+		names = append(names, fmt.Sprintf("%s (%d)", lens[i].name, lens[i].n))
+	}
+	if len(excluded) > maxExcludedListed {
+		names = append(names, fmt.Sprintf("and %d more",
+			len(excluded)-maxExcludedListed))
+	}
+	return fmt.Sprintf("WARNING: excluded %d sequences shorter than %d "+
+		"bases: %s", len(excluded), minLen, strings.Join(names, ", "))
+}
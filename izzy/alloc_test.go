@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestMultinomialCounts(t *testing.T) {
+	lens := []lenGroup{
+		{g: "a", n: 1000, name: "a1"},
+		{g: "a", n: 100, name: "a2"}, // Too short.
+		{g: "a", n: 3000, name: "a3"},
+		{g: "b", n: 50, name: "b1"}, // Too short, the whole group.
+		{g: "c", n: 2000, name: "c1"},
+	}
+	ratios := map[string]float64{"a": 0.5, "b": 0.25, "c": 0.25}
+	const n = 100000
+	rng := rand.New(rand.NewPCG(0, 0))
+	counts, excluded, err := multinomialCounts(lens, ratios, n, 300, rng)
+	if err != nil {
+		t.Fatalf("multinomialCounts(...) failed: %v", err)
+	}
+	if want := []int{1, 3}; !reflect.DeepEqual(excluded, want) {
+		t.Errorf("excluded=%v, want %v", excluded, want)
+	}
+	if got := sum(counts); got != n {
+		t.Errorf("sum(counts)=%d, want %d", got, n)
+	}
+	// b's share goes to a and c, a's share is split 1:3.
+	want := []float64{n / 6.0, 0, n / 2.0, 0, n / 3.0}
+	for i := range counts {
+		if float64(counts[i]) < want[i]*0.98 || float64(counts[i]) > want[i]*1.02 {
+			t.Errorf("counts[%d]=%d, want ~%f", i, counts[i], want[i])
+		}
+	}
+
+	if _, _, err := multinomialCounts(lens, ratios, n, 5000, rng); err == nil {
+		t.Errorf("multinomialCounts(...) with no eligible sequences succeeded, " +
+			"want error")
+	}
+}
+
+func TestGroupCounts(t *testing.T) {
+	defer func(s bool) { *singleEnd = s }(*singleEnd)
+	lens := []lenGroup{
+		{g: "a", n: 1000, name: "a1"},
+		{g: "a", n: 100, name: "a2"}, // Too short.
+		{g: "a", n: 2000, name: "a3"},
+		{g: "b", n: 50, name: "b1"}, // Too short, but has no reads.
+		{g: "c", n: 2000, name: "c1"},
+	}
+	tests := []struct {
+		single bool
+		reads  map[string]float64
+		want   []int
+	}{
+		{true, map[string]float64{"a": 300, "c": 7}, []int{100, 0, 200, 0, 7}},
+		{true, map[string]float64{"a": 100, "c": 0}, []int{33, 0, 67, 0, 0}},
+		{true, map[string]float64{"a": 4.4}, []int{1, 0, 3, 0, 0}},
+		{false, map[string]float64{"a": 300, "c": 7}, []int{50, 0, 100, 0, 4}},
+	}
+	for _, test := range tests {
+		*singleEnd = test.single
+		got, excluded, err := groupCounts(lens, test.reads, 300)
+		if err != nil {
+			t.Fatalf("groupCounts(%v) failed: %v", test.reads, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("groupCounts(%v)=%v, want %v", test.reads, got, test.want)
+		}
+		if want := []int{1, 3}; !reflect.DeepEqual(excluded, want) {
+			t.Errorf("groupCounts(%v) excluded %v, want %v",
+				test.reads, excluded, want)
+		}
+	}
+
+	*singleEnd = true
+	if _, _, err := groupCounts(lens, map[string]float64{"b": 10},
+		300); err == nil {
+		t.Errorf("groupCounts(...) with no eligible sequences succeeded, " +
+			"want error")
+	}
+}
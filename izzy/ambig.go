@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/fluhus/biostuff/sequtil"
+)
+
+// Handling of non-ACGT bases in the input sequences.
+
+// Modes of handling non-ACGT bases.
+const (
+	ambigDrop    = "drop"    // Drop contigs that have any
+	ambigSplit   = "split"   // Split contigs at runs of them
+	ambigResolve = "resolve" // Replace with random compatible bases
+	ambigKeep    = "keep"    // Keep as N in the reads
+)
+
+// Compatible bases of each IUPAC code.
+var iupacBases = map[byte]string{
+	'R': "AG", 'Y': "CT", 'S': "CG", 'W': "AT", 'K': "GT", 'M': "AC",
+	'B': "CGT", 'D': "AGT", 'H': "ACT", 'V': "ACG", 'N': "ACGT",
+}
+
+// A range of a contig to simulate reads from.
+type piece struct {
+	start, end int
+}
+
+// Returns the ranges of seq to simulate reads from, according to the
+// ambiguous base mode.
+func contigPieces(seq []byte, mode string) []piece {
+	switch mode {
+	case ambigDrop:
+		if !isNucs(seq) {
+			return nil
+		}
+	case ambigSplit:
+		var result []piece
+		start := -1 // Start of the current run of ACGT.
+		for i, b := range seq {
+			if sequtil.Ntoi(b) == -1 {
+				if start != -1 {
+					result = append(result, piece{start, i})
+					start = -1
+				}
+			} else if start == -1 {
+				start = i
+			}
+		}
+		if start != -1 {
+			result = append(result, piece{start, len(seq)})
+		}
+		return result
+	}
+	return []piece{{0, len(seq)}}
+}
+
+// Modifies the non-ACGT bases of seq in place, according to the ambiguous
+// base mode. In resolve mode, characters that are not IUPAC codes are
+// treated as N.
+func fixAmbiguous(seq []byte, mode string, rng *rand.Rand) {
+	switch mode {
+	case ambigResolve:
+		for i, b := range seq {
+			if sequtil.Ntoi(b) != -1 {
+				continue
+			}
+			bases, ok := iupacBases[upper(b)]
+			if !ok {
+				bases = iupacBases['N']
+			}
+			seq[i] = bases[rng.IntN(len(bases))]
+		}
+	case ambigKeep:
+		for i, b := range seq {
+			if sequtil.Ntoi(b) == -1 {
+				seq[i] = 'N'
+			}
+		}
+	}
+}
+
+// Counts of non-ACGT bases in the input.
+type ambigStats struct {
+	contigs int // Contigs with non-ACGT bases
+	bases   int // Non-ACGT bases
+	pieces  int // Pieces that the contigs were split into
+}
+
+// Adds a contig's counts.
+func (s *ambigStats) add(seq []byte, pieces []piece) {
+	n := 0
+	for _, b := range seq {
+		if sequtil.Ntoi(b) == -1 {
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	s.contigs++
+	s.bases += n
+	s.pieces += len(pieces)
+}
+
+// Returns a report of what was done with non-ACGT bases.
+func (s *ambigStats) report(mode string) string {
+	if s.contigs == 0 {
+		return "No non-ACGT bases found"
+	}
+	switch mode {
+	case ambigDrop:
+		return fmt.Sprintf("Dropped %d contigs with %d non-ACGT bases "+
+			"(see -nmode)", s.contigs, s.bases)
+	case ambigSplit:
+		return fmt.Sprintf("Split %d contigs into %d pieces, "+
+			"skipping %d non-ACGT bases", s.contigs, s.pieces, s.bases)
+	case ambigResolve:
+		return fmt.Sprintf("Resolved %d non-ACGT bases in %d contigs",
+			s.bases, s.contigs)
+	case ambigKeep:
+		return fmt.Sprintf("Kept %d non-ACGT bases as N in %d contigs",
+			s.bases, s.contigs)
+	}
+	panic(fmt.Sprintf("bad mode: %q", mode))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContigPieces(t *testing.T) {
+	tests := []struct {
+		seq  string
+		mode string
+		want []piece
+	}{
+		{"ACGT", ambigDrop, []piece{{0, 4}}},
+		{"ACNGT", ambigDrop, nil},
+		{"ACNGT", ambigResolve, []piece{{0, 5}}},
+		{"ACNGT", ambigKeep, []piece{{0, 5}}},
+		{"ACGT", ambigSplit, []piece{{0, 4}}},
+		{"ACNNGTRA", ambigSplit, []piece{{0, 2}, {4, 6}, {7, 8}}},
+		{"NNacNN", ambigSplit, []piece{{2, 4}}},
+		{"NNN", ambigSplit, nil},
+	}
+	for _, test := range tests {
+		got := contigPieces([]byte(test.seq), test.mode)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("contigPieces(%q, %q)=%v, want %v",
+				test.seq, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestFixAmbiguous(t *testing.T) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	for range 100 {
+		seq := []byte("ACRYNnk-")
+		fixAmbiguous(seq, ambigResolve, rng)
+		if string(seq[:2]) != "AC" {
+			t.Fatalf("fixAmbiguous(resolve) changed ACGT bases: %q", seq)
+		}
+		for i, compat := range []string{"AG", "CT", "ACGT", "ACGT", "GT",
+			"ACGT"} {
+			if !strings.ContainsRune(compat, rune(seq[i+2])) {
+				t.Fatalf("fixAmbiguous(resolve)[%d]=%c, want one of %q",
+					i+2, seq[i+2], compat)
+			}
+		}
+	}
+
+	seq := []byte("ACRYNnk-T")
+	fixAmbiguous(seq, ambigKeep, rng)
+	if want := "ACNNNNNNT"; string(seq) != want {
+		t.Errorf("fixAmbiguous(keep)=%q, want %q", seq, want)
+	}
+}
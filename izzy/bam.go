@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"math"
+	"strconv"
+
+	"github.com/fluhus/biostuff/formats/sam"
+	"github.com/fluhus/gostuff/aio"
+	"github.com/fluhus/gostuff/snm"
+	"golang.org/x/exp/maps"
+)
+
+// Encoding follows the SAM/BAM format specification:
+// https://samtools.github.io/hts-specs/SAMv1.pdf
+
+const (
+	// Maximal uncompressed size of a BGZF block, same as in htslib.
+	bgzfBlockSize = 0xff00
+
+	// Maximal total size of a BGZF block.
+	bgzfMaxBlock = 1 << 16
+
+	// Size of a BGZF block's header and footer.
+	bgzfOverhead = 26
+)
+
+// BGZF end-of-file marker, an empty block.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00,
+	0x42, 0x43, 0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+// Returns b compressed as a series of BGZF blocks.
+// Concatenated outputs make a valid BGZF stream, without an EOF marker.
+func bgzfBytes(b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for len(b) > 0 {
+		n := min(len(b), bgzfBlockSize)
+		if err := bgzfBlock(buf, b[:n]); err != nil {
+			return nil, err
+		}
+		b = b[n:]
+	}
+	return buf.Bytes(), nil
+}
+
+// Writes a single BGZF block with the given data to buf.
+func bgzfBlock(buf *bytes.Buffer, b []byte) error {
+	cmp, err := deflate(b, 1)
+	if err != nil {
+		return err
+	}
+	if len(cmp)+bgzfOverhead > bgzfMaxBlock { // Incompressible data.
+		if cmp, err = deflate(b, flate.NoCompression); err != nil {
+			return err
+		}
+	}
+	buf.Write([]byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 6, 0,
+		'B', 'C', 2, 0})
+	binary.Write(buf, binary.LittleEndian, uint16(len(cmp)+bgzfOverhead-1))
+	buf.Write(cmp)
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(b))
+	binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+	return nil
+}
+
+// Returns b compressed as raw deflate data.
+func deflate(b []byte, level int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the uncompressed BAM header for the given SAM header text and
+// reference sequences.
+func bamHeader(text []byte, lens []lenGroup) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("BAM\x01")
+	binary.Write(buf, binary.LittleEndian, int32(len(text)))
+	buf.Write(text)
+	refs := samRefs(lens)
+	binary.Write(buf, binary.LittleEndian, int32(len(refs)))
+	for _, r := range refs {
+		binary.Write(buf, binary.LittleEndian, int32(len(r.name)+1))
+		buf.WriteString(r.name)
+		buf.WriteByte(0)
+		binary.Write(buf, binary.LittleEndian, int32(r.n))
+	}
+	return buf.Bytes()
+}
+
+// Appends the uncompressed BAM encoding of s to buf.
+// refID is the index of s's reference in the header, also used for its mate.
+func bamRecord(buf *bytes.Buffer, s *sam.SAM, refID int) error {
+	cigar, err := bamCigar(s.Cigar)
+	if err != nil {
+		return err
+	}
+	tags := bamTags(s.Tags)
+
+	blockSize := 32 + len(s.Qname) + 1 + 4*len(cigar) +
+		(len(s.Seq)+1)/2 + len(s.Seq) + len(tags)
+	refLen := 0
+	for _, c := range cigar {
+		if op := c & 0xf; op == 0 || op == 2 { // M or D
+			refLen += int(c >> 4)
+		}
+	}
+	pos := s.Pos - 1
+
+	w := func(v any) { binary.Write(buf, binary.LittleEndian, v) }
+	w(int32(blockSize))
+	w(int32(refID))
+	w(int32(pos))
+	w(uint8(len(s.Qname) + 1))
+	w(uint8(s.Mapq))
+	w(uint16(reg2bin(pos, pos+max(refLen, 1))))
+	w(uint16(len(cigar)))
+	w(uint16(s.Flag))
+	w(int32(len(s.Seq)))
+	w(int32(refID))
+	w(int32(s.Pnext - 1))
+	w(int32(s.Tlen))
+	buf.WriteString(s.Qname)
+	buf.WriteByte(0)
+	w(cigar)
+	for i := 0; i < len(s.Seq); i += 2 {
+		b := bamNucs[s.Seq[i]] << 4
+		if i+1 < len(s.Seq) {
+			b |= bamNucs[s.Seq[i+1]]
+		}
+		buf.WriteByte(b)
+	}
+	for i := range len(s.Qual) {
+		buf.WriteByte(s.Qual[i] - 33)
+	}
+	buf.Write(tags)
+	return nil
+}
+
+// Returns the binary encoding of a CIGAR string.
+func bamCigar(cigar string) ([]uint32, error) {
+	var result []uint32
+	for len(cigar) > 0 {
+		i := 0
+		for i < len(cigar) && cigar[i] >= '0' && cigar[i] <= '9' {
+			i++
+		}
+		if i == len(cigar) {
+			return nil, fmt.Errorf("bad cigar: %q", cigar)
+		}
+		n, err := strconv.Atoi(cigar[:i])
+		if err != nil {
+			return nil, err
+		}
+		op := bytes.IndexByte([]byte("MIDNSHP=X"), cigar[i])
+		if op == -1 {
+			return nil, fmt.Errorf("bad cigar operation: %q", cigar[i])
+		}
+		result = append(result, uint32(n)<<4|uint32(op))
+		cigar = cigar[i+1:]
+	}
+	return result, nil
+}
+
+// Returns the binary encoding of SAM tags, sorted by name.
+// Supports integer and string tags.
+func bamTags(tags map[string]any) []byte {
+	buf := &bytes.Buffer{}
+	for _, k := range snm.Sorted(maps.Keys(tags)) {
+		buf.WriteString(k)
+		switch v := tags[k].(type) {
+		case int:
+			buf.WriteByte('i')
+			binary.Write(buf, binary.LittleEndian, int32(v))
+		case string:
+			buf.WriteByte('Z')
+			buf.WriteString(v)
+			buf.WriteByte(0)
+		default:
+			panic(fmt.Sprintf("unsupported tag type: %T", v))
+		}
+	}
+	return buf.Bytes()
+}
+
+// Maps nucleotide characters to their 4-bit BAM encoding.
+var bamNucs = func() [256]byte {
+	var result [256]byte
+	for i := range result {
+		result[i] = 15 // N
+	}
+	for i, b := range []byte("=ACMGRSVTWYHKDBN") {
+		result[b] = byte(i)
+	}
+	return result
+}()
+
+// Returns the BAI bin of a 0-based interval, end exclusive.
+// Taken from the BAM specification.
+func reg2bin(beg, end int) int {
+	end--
+	switch {
+	case beg>>14 == end>>14:
+		return ((1<<15)-1)/7 + (beg >> 14)
+	case beg>>17 == end>>17:
+		return ((1<<12)-1)/7 + (beg >> 17)
+	case beg>>20 == end>>20:
+		return ((1<<9)-1)/7 + (beg >> 20)
+	case beg>>23 == end>>23:
+		return ((1<<6)-1)/7 + (beg >> 23)
+	case beg>>26 == end>>26:
+		return ((1<<3)-1)/7 + (beg >> 26)
+	}
+	return 0
+}
+
+// Returns an iterator over the alignments in a BAM file.
+func bamFile(file string) iter.Seq2[*sam.SAM, error] {
+	return func(yield func(*sam.SAM, error) bool) {
+		f, err := aio.OpenRaw(file)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer f.Close()
+		z, err := gzip.NewReader(f) // BGZF is a multi-member gzip.
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		r := bufio.NewReader(z)
+		refs, err := readBAMHeader(r)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for {
+			s, err := readBAMRecord(r, refs)
+			if err == io.EOF {
+				return
+			}
+			if !yield(s, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Reads a BAM header and returns the reference names.
+func readBAMHeader(r io.Reader) ([]string, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != "BAM\x01" {
+		return nil, fmt.Errorf("not a BAM file")
+	}
+	var ltext int32
+	if err := binary.Read(r, binary.LittleEndian, &ltext); err != nil {
+		return nil, err
+	}
+	if ltext < 0 {
+		return nil, fmt.Errorf("bad BAM header text length: %d", ltext)
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(ltext)); err != nil {
+		return nil, err
+	}
+	var nref int32
+	if err := binary.Read(r, binary.LittleEndian, &nref); err != nil {
+		return nil, err
+	}
+	if nref < 0 {
+		return nil, fmt.Errorf("bad number of BAM references: %d", nref)
+	}
+	refs := make([]string, nref)
+	for i := range refs {
+		var lname int32
+		if err := binary.Read(r, binary.LittleEndian, &lname); err != nil {
+			return nil, err
+		}
+		if lname < 1 {
+			return nil, fmt.Errorf("bad BAM reference name length: %d", lname)
+		}
+		name := make([]byte, lname+4) // Including the reference length.
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		refs[i] = string(name[:lname-1])
+	}
+	return refs, nil
+}
+
+// Minimal number of bytes after the type of a BAM tag, by type.
+// For arrays, this is the subtype and count.
+var bamTagSize = map[byte]int{
+	'A': 1, 'c': 1, 'C': 1, 's': 2, 'S': 2, 'i': 4, 'I': 4, 'f': 4,
+	'Z': 0, 'H': 0, 'B': 5,
+}
+
+// Reads a single BAM record. Returns io.EOF at the end of the input.
+// Only integer and string tags are decoded, others are skipped.
+func readBAMRecord(r io.Reader, refs []string) (*sam.SAM, error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err // Plain EOF if no more records.
+	}
+	if size < 32 {
+		return nil, fmt.Errorf("bad BAM record size: %d", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("truncated BAM record: %w", err)
+	}
+	le := binary.LittleEndian
+	refName := func(id int32) (string, error) {
+		if id == -1 {
+			return "*", nil
+		}
+		if id < 0 || int(id) >= len(refs) {
+			return "", fmt.Errorf("bad reference ID: %d", id)
+		}
+		return refs[id], nil
+	}
+
+	s := &sam.SAM{}
+	var err error
+	if s.Rname, err = refName(int32(le.Uint32(b))); err != nil {
+		return nil, err
+	}
+	s.Pos = int(int32(le.Uint32(b[4:]))) + 1
+	lname := int(b[8])
+	s.Mapq = int(b[9])
+	ncigar := int(le.Uint16(b[12:]))
+	s.Flag = sam.Flag(le.Uint16(b[14:]))
+	lseq := int(int32(le.Uint32(b[16:])))
+	if lname < 1 {
+		return nil, fmt.Errorf("bad BAM read name length: %d", lname)
+	}
+	if lseq < 0 {
+		return nil, fmt.Errorf("bad BAM sequence length: %d", lseq)
+	}
+	nextRef := int32(le.Uint32(b[20:]))
+	s.Pnext = int(int32(le.Uint32(b[24:]))) + 1
+	s.Tlen = int(int32(le.Uint32(b[28:])))
+	if s.Rnext, err = refName(nextRef); err != nil {
+		return nil, err
+	}
+	if s.Rnext == s.Rname && s.Rnext != "*" {
+		s.Rnext = "="
+	}
+	rest := b[32:]
+	if len(rest) < lname+4*ncigar+(lseq+1)/2+lseq {
+		return nil, fmt.Errorf("truncated BAM record")
+	}
+	s.Qname = string(rest[:lname-1])
+	rest = rest[lname:]
+
+	var cigar []byte
+	for i := range ncigar {
+		c := le.Uint32(rest[4*i:])
+		cigar = strconv.AppendInt(cigar, int64(c>>4), 10)
+		cigar = append(cigar, "MIDNSHP=X"[c&0xf])
+	}
+	rest = rest[4*ncigar:]
+	s.Cigar = string(cigar)
+	if s.Cigar == "" {
+		s.Cigar = "*"
+	}
+
+	seq := make([]byte, lseq)
+	for i := range seq {
+		nuc := rest[i/2] >> 4
+		if i%2 == 1 {
+			nuc = rest[i/2] & 0xf
+		}
+		seq[i] = "=ACMGRSVTWYHKDBN"[nuc]
+	}
+	rest = rest[(lseq+1)/2:]
+	s.Seq = string(seq)
+	if s.Seq == "" {
+		s.Seq = "*"
+	}
+
+	qual := make([]byte, lseq)
+	for i := range qual {
+		qual[i] = rest[i] + 33
+	}
+	if lseq > 0 && rest[0] == 0xff {
+		s.Qual = "*"
+	} else {
+		s.Qual = string(qual)
+	}
+	rest = rest[lseq:]
+
+	s.Tags = map[string]any{}
+	for len(rest) > 0 {
+		if len(rest) < 3 {
+			return nil, fmt.Errorf("truncated BAM tag: %q", rest)
+		}
+		tag, typ := string(rest[:2]), rest[2]
+		rest = rest[3:]
+		if n := bamTagSize[typ]; len(rest) < n {
+			return nil, fmt.Errorf("truncated BAM tag %s: need %d bytes, "+
+				"found %d", tag, n, len(rest))
+		}
+		switch typ {
+		case 'A', 'c', 'C':
+			if typ == 'c' {
+				s.Tags[tag] = int(int8(rest[0]))
+			} else if typ == 'C' {
+				s.Tags[tag] = int(rest[0])
+			} else {
+				s.Tags[tag] = rest[0]
+			}
+			rest = rest[1:]
+		case 's', 'S':
+			if typ == 's' {
+				s.Tags[tag] = int(int16(le.Uint16(rest)))
+			} else {
+				s.Tags[tag] = int(le.Uint16(rest))
+			}
+			rest = rest[2:]
+		case 'i', 'I', 'f':
+			switch typ {
+			case 'i':
+				s.Tags[tag] = int(int32(le.Uint32(rest)))
+			case 'I':
+				s.Tags[tag] = int(le.Uint32(rest))
+			case 'f':
+				s.Tags[tag] = float64(math.Float32frombits(le.Uint32(rest)))
+			}
+			rest = rest[4:]
+		case 'Z', 'H':
+			i := bytes.IndexByte(rest, 0)
+			if i == -1 {
+				return nil, fmt.Errorf("unterminated tag: %s", tag)
+			}
+			s.Tags[tag] = string(rest[:i])
+			rest = rest[i+1:]
+		case 'B':
+			if !bytes.Contains([]byte("cCsSiIf"), rest[:1]) { // Numeric only.
+				return nil, fmt.Errorf("bad array type of tag %s: %q",
+					tag, rest[0])
+			}
+			size := bamTagSize[rest[0]]
+			n := int(le.Uint32(rest[1:]))
+			if n < 0 || n > (len(rest)-5)/size {
+				return nil, fmt.Errorf("truncated BAM tag %s: array of %d "+
+					"elements in %d bytes", tag, n, len(rest)-5)
+			}
+			rest = rest[5+n*size:] // Skipped.
+		default:
+			return nil, fmt.Errorf("bad tag type: %q", typ)
+		}
+	}
+	return s, nil
+}
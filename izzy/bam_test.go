@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/sam"
+)
+
+func TestBamRecord(t *testing.T) {
+	s := &sam.SAM{
+		Qname: "read1",
+		Flag:  99,
+		Pos:   101,
+		Mapq:  60,
+		Cigar: "3M1I2M",
+		Pnext: 301,
+		Tlen:  250,
+		Seq:   "ACGTNA",
+		Qual:  "IIII#I",
+		Tags:  map[string]any{"NM": 1, "MD": "5"},
+	}
+	buf := &bytes.Buffer{}
+	if err := bamRecord(buf, s, 3); err != nil {
+		t.Fatalf("bamRecord(...) failed: %v", err)
+	}
+
+	b := buf.Bytes()
+	le := binary.LittleEndian
+	if got, want := int(le.Uint32(b)), len(b)-4; got != want {
+		t.Fatalf("block_size=%d, want %d", got, want)
+	}
+	ints := []struct {
+		name      string
+		got, want int
+	}{
+		{"refID", int(le.Uint32(b[4:])), 3},
+		{"pos", int(le.Uint32(b[8:])), 100},
+		{"l_read_name", int(b[12]), 6},
+		{"mapq", int(b[13]), 60},
+		{"bin", int(le.Uint16(b[14:])), 4681},
+		{"n_cigar_op", int(le.Uint16(b[16:])), 3},
+		{"flag", int(le.Uint16(b[18:])), 99},
+		{"l_seq", int(le.Uint32(b[20:])), 6},
+		{"next_refID", int(le.Uint32(b[24:])), 3},
+		{"next_pos", int(le.Uint32(b[28:])), 300},
+		{"tlen", int(le.Uint32(b[32:])), 250},
+		{"cigar[1]", int(le.Uint32(b[36+6+4:])), 1<<4 | 1},
+	}
+	for _, x := range ints {
+		if x.got != x.want {
+			t.Errorf("%s=%d, want %d", x.name, x.got, x.want)
+		}
+	}
+	rest := b[36+6+12:]
+	want := []byte{0x12, 0x48, 0xf1, 40, 40, 40, 40, 2, 40,
+		'M', 'D', 'Z', '5', 0, 'N', 'M', 'i', 1, 0, 0, 0}
+	if !bytes.Equal(rest, want) {
+		t.Errorf("seq, qual and tags=%v, want %v", rest, want)
+	}
+}
+
+func TestBgzfBytes(t *testing.T) {
+	data := bytes.Repeat([]byte("ACGTTGCAAT"), 20000)
+	b, err := bgzfBytes(data)
+	if err != nil {
+		t.Fatalf("bgzfBytes(...) failed: %v", err)
+	}
+	b = append(b, bgzfEOF...)
+
+	// Check block sizes.
+	nblocks := 0
+	for rest := b; len(rest) > 0; nblocks++ {
+		bsize := int(binary.LittleEndian.Uint16(rest[16:])) + 1
+		if bsize > len(rest) {
+			t.Fatalf("block size %d exceeds remaining %d", bsize, len(rest))
+		}
+		rest = rest[bsize:]
+	}
+	if want := (len(data)+bgzfBlockSize-1)/bgzfBlockSize + 1; nblocks != want {
+		t.Errorf("got %d blocks, want %d", nblocks, want)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("gzip.NewReader(...) failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll(...) failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decompressed data does not match input")
+	}
+}
+
+func TestReadBAMRecord(t *testing.T) {
+	want := &sam.SAM{
+		Qname: "read1",
+		Flag:  147,
+		Rname: "chr2",
+		Pos:   101,
+		Mapq:  60,
+		Cigar: "3M1I2M",
+		Rnext: "=",
+		Pnext: 31,
+		Tlen:  -250,
+		Seq:   "ACGTNA",
+		Qual:  "IIII#I",
+		Tags:  map[string]any{"NM": 1, "MD": "5"},
+	}
+	lens := []lenGroup{{"g", 1000, "chr1", 0, 1000}, {"g", 2000, "chr2", 1, 2000}}
+	buf := bytes.NewBuffer(bamHeader([]byte("@HD\tVN:1.6\n"), lens))
+	if err := bamRecord(buf, want, 1); err != nil {
+		t.Fatalf("bamRecord(...) failed: %v", err)
+	}
+
+	refs, err := readBAMHeader(buf)
+	if err != nil {
+		t.Fatalf("readBAMHeader(...) failed: %v", err)
+	}
+	if wantRefs := []string{"chr1", "chr2"}; !reflect.DeepEqual(refs, wantRefs) {
+		t.Errorf("readBAMHeader(...)=%v, want %v", refs, wantRefs)
+	}
+	got, err := readBAMRecord(buf, refs)
+	if err != nil {
+		t.Fatalf("readBAMRecord(...) failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readBAMRecord(...)=%+v, want %+v", got, want)
+	}
+	if _, err := readBAMRecord(buf, refs); err != io.EOF {
+		t.Errorf("readBAMRecord(...) at end returned %v, want EOF", err)
+	}
+}
+
+func TestReadBAMRecord_malformed(t *testing.T) {
+	s := &sam.SAM{
+		Qname: "read1", Flag: 99, Rname: "chr1", Pos: 1, Mapq: 60,
+		Cigar: "6M", Rnext: "=", Pnext: 31, Tlen: 250, Seq: "ACGTNA",
+		Qual: "IIII#I", Tags: map[string]any{"NM": 1, "MD": "5"},
+	}
+	buf := &bytes.Buffer{}
+	if err := bamRecord(buf, s, 0); err != nil {
+		t.Fatalf("bamRecord(...) failed: %v", err)
+	}
+	body := buf.Bytes()[4:] // Without the size.
+	refs := []string{"chr1"}
+
+	// Returns a record with the given body.
+	record := func(b []byte) io.Reader {
+		out := binary.LittleEndian.AppendUint32(nil, uint32(len(b)))
+		return bytes.NewReader(append(out, b...))
+	}
+	withTag := func(tag ...byte) []byte {
+		return append(slices.Clone(body), tag...)
+	}
+	zeroName := slices.Clone(body)
+	zeroName[8] = 0
+	negSeq := slices.Clone(body)
+	binary.LittleEndian.PutUint32(negSeq[16:], 0xffffffff)
+
+	tests := [][]byte{
+		body[:20],
+		body[:len(body)-9], // In the middle of the sequence.
+		zeroName,
+		negSeq,
+		withTag('X'),
+		withTag('X', 'Y', 'i', 1, 2),
+		withTag('X', 'Y', 's'),
+		withTag('X', 'Y', 'Z', 'a'),
+		withTag('X', 'Y', 'B', 'i', 100, 0, 0, 0, 1, 2, 3, 4),
+		withTag('X', 'Y', 'B', 'i', 0xff, 0xff, 0xff, 0xff),
+		withTag('X', 'Y', 'B', 'q', 0, 0, 0, 0),
+		withTag('X', 'Y', 'B', 'Z', 1, 0, 0, 0, 'a', 0),
+		withTag('X', 'Y', 'B', 'H', 1, 0, 0, 0, 'a', 0),
+		withTag('X', 'Y', 'B', 'A', 1, 0, 0, 0, 'a'),
+		withTag('X', 'Y', 'B', 'B', 1, 0, 0, 0, 'a', 0, 0, 0, 0),
+		withTag('X', 'Y', 'B'),
+		withTag('X', 'Y', 'q', 1),
+	}
+	for _, b := range tests {
+		if got, err := readBAMRecord(record(b), refs); err == nil {
+			t.Errorf("readBAMRecord(%v)=%+v, want error", b, got)
+		}
+	}
+	// Truncated anywhere, a record should not panic.
+	for i := range body {
+		readBAMRecord(record(body[:i]), refs)
+	}
+
+	header := bamHeader([]byte("@HD\tVN:1.6\n"),
+		[]lenGroup{{"g", 1000, "chr1", 0, 1000}})
+	zeroRef := slices.Clone(header)
+	binary.LittleEndian.PutUint32(zeroRef[len(header)-13:], 0)
+	negRefs := slices.Clone(header)
+	binary.LittleEndian.PutUint32(negRefs[len(header)-17:], 0xffffffff)
+	for _, h := range [][]byte{header[:len(header)-3], zeroRef, negRefs} {
+		if got, err := readBAMHeader(bytes.NewReader(h)); err == nil {
+			t.Errorf("readBAMHeader(%v)=%v, want error", h, got)
+		}
+	}
+	for i := range header {
+		readBAMHeader(bytes.NewReader(header[:i]))
+	}
+}
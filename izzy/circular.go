@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/fluhus/biostuff/formats/fasta"
+)
+
+// Handling of circular contigs.
+
+// Header tokens that mark a contig as circular, in lower case.
+var circularTokens = map[string]bool{
+	"circular":          true,
+	"circular=true":     true,
+	"circular=yes":      true,
+	"topology=circular": true,
+}
+
+// Checks whether a contig should be treated as circular, according to the
+// flags and its fasta name.
+func isCircular(name []byte, all bool, pattern *regexp.Regexp) bool {
+	if all || (pattern != nil && pattern.Match(name)) {
+		return true
+	}
+	for _, word := range bytes.Fields(name) {
+		word = bytes.Trim(word, "[]")
+		if circularTokens[string(bytes.ToLower(word))] {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether reads from a contig should wrap around its end, which
+// requires it to be circular and simulated as a whole.
+func isWholeCircular(fa *fasta.Fasta, pieces []piece) bool {
+	return len(pieces) == 1 && pieces[0] == piece{0, len(fa.Sequence)} &&
+		isCircular(fa.Name, *circularAll, *circularRE)
+}
+
+// Returns a 1-based position on a circular sequence of length n, for
+// positions that continue past its end.
+func wrapPos(pos, n int) int {
+	return (pos-1)%n + 1
+}
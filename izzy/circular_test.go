@@ -0,0 +1,32 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestIsCircular(t *testing.T) {
+	tests := []struct {
+		name    string
+		all     bool
+		pattern *regexp.Regexp
+		want    bool
+	}{
+		{"chr1", false, nil, false},
+		{"chr1", true, nil, true},
+		{"plasmid1 len=2000", false, regexp.MustCompile("^plasmid"), true},
+		{"chr1 len=2000", false, regexp.MustCompile("^plasmid"), false},
+		{"contig_1 length=5000 circular=true", false, nil, true},
+		{"contig_1 length=5000 circular=false", false, nil, false},
+		{"NC_1 [topology=circular]", false, nil, true},
+		{"NC_1 Circular", false, nil, true},
+		{"NC_1 noncircular", false, nil, false},
+	}
+	for _, test := range tests {
+		if got := isCircular([]byte(test.name), test.all,
+			test.pattern); got != test.want {
+			t.Errorf("isCircular(%q,%v,%v)=%v, want %v",
+				test.name, test.all, test.pattern, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Conversion of InSilicoSeq models, reproducing fromiss.py.
+
+// Maps nucleotides to their index in izzy's models.
+var issNtoi = map[string]int{"A": 0, "C": 1, "G": 2, "T": 3}
+
+// Converts an InSilicoSeq model to izzy's JSON structure.
+func issToModel(m map[string]*ndarray) (map[string]any, error) {
+	for _, k := range []string{"model", "read_length", "insert_size",
+		"mean_count_forward", "mean_count_reverse",
+		"quality_hist_forward", "quality_hist_reverse",
+		"subst_choices_forward", "subst_choices_reverse",
+		"ins_forward", "ins_reverse", "del_forward", "del_reverse"} {
+		if m[k] == nil {
+			return nil, fmt.Errorf("missing array: %q", k)
+		}
+	}
+
+	d := map[string]any{}
+	name, ok := m["model"].tolist().(string)
+	if !ok {
+		return nil, fmt.Errorf("model name is not a string: %v",
+			m["model"].tolist())
+	}
+	d["name"] = name
+	d["readLen"] = m["read_length"].tolist()
+	d["insertLen"] = m["insert_size"].tolist()
+
+	var err error
+	if d["meanCountForward"], err = issMeanCount(m["mean_count_forward"]); err != nil {
+		return nil, fmt.Errorf("mean_count_forward: %w", err)
+	}
+	if d["meanCountReverse"], err = issMeanCount(m["mean_count_reverse"]); err != nil {
+		return nil, fmt.Errorf("mean_count_reverse: %w", err)
+	}
+	d["qualityHistForward"] = m["quality_hist_forward"].tolist()
+	d["qualityHistReverse"] = m["quality_hist_reverse"].tolist()
+	if d["substChoicesForward"], err = issSubst(m["subst_choices_forward"]); err != nil {
+		return nil, fmt.Errorf("subst_choices_forward: %w", err)
+	}
+	if d["substChoicesReverse"], err = issSubst(m["subst_choices_reverse"]); err != nil {
+		return nil, fmt.Errorf("subst_choices_reverse: %w", err)
+	}
+	indels := []struct{ from, to string }{
+		{"ins_forward", "insForward"}, {"ins_reverse", "insReverse"},
+		{"del_forward", "delForward"}, {"del_reverse", "delReverse"},
+	}
+	for _, x := range indels {
+		if d[x.to], err = issIndel(m[x.from]); err != nil {
+			return nil, fmt.Errorf("%s: %w", x.from, err)
+		}
+	}
+	return d, nil
+}
+
+// Converts substitution dicts to per-position CDFs.
+// Reproduces subst_to_arrays in fromiss.py.
+func issSubst(a *ndarray) ([][4][]float64, error) {
+	dicts, err := a.items()
+	if err != nil {
+		return nil, err
+	}
+	var result [][4][]float64
+	for _, dv := range dicts {
+		d, ok := dv.(*pyDict)
+		if !ok {
+			return nil, fmt.Errorf("element is %T, want dict", dv)
+		}
+		var arr [4][]float64 // Missing characters stay null.
+		for i, k := range d.keys {
+			char, err := issNuc(k)
+			if err != nil {
+				return nil, err
+			}
+			pairs, err := pyZip(d.values[i])
+			if err != nil {
+				return nil, err
+			}
+			probs := make([]float64, 4)
+			for _, pair := range pairs {
+				if len(pair) != 2 {
+					return nil, fmt.Errorf("substitution has %d values, want 2",
+						len(pair))
+				}
+				schar, err := issNuc(pair[0])
+				if err != nil {
+					return nil, err
+				}
+				if probs[schar], err = pyFloat(pair[1]); err != nil {
+					return nil, err
+				}
+			}
+			cumsum(probs)
+			if last := probs[len(probs)-1]; last != 0 {
+				for j := range probs {
+					probs[j] /= last
+				}
+			} else {
+				// All substitution probabilities are 0
+				// -> make it 1 for the original char.
+				for j := char; j < len(probs); j++ {
+					probs[j] = 1
+				}
+			}
+			arr[char] = probs
+		}
+		result = append(result, arr)
+	}
+	return result, nil
+}
+
+// Converts indel dicts to per-position probabilities.
+// Reproduces indel_to_arrays in fromiss.py.
+func issIndel(a *ndarray) ([][4]any, error) {
+	dicts, err := a.items()
+	if err != nil {
+		return nil, err
+	}
+	var result [][4]any
+	for _, dv := range dicts {
+		d, ok := dv.(*pyDict)
+		if !ok {
+			return nil, fmt.Errorf("element is %T, want dict", dv)
+		}
+		arr := [4]any{0, 0, 0, 0}
+		for i, k := range d.keys {
+			char, err := issNuc(k)
+			if err != nil {
+				return nil, err
+			}
+			arr[char] = tolist(d.values[i])
+		}
+		result = append(result, arr)
+	}
+	return result, nil
+}
+
+// Converts mean quality counts to a CDF.
+// Reproduces mean_count_to_array in fromiss.py.
+func issMeanCount(a *ndarray) ([]float64, error) {
+	if len(a.shape) != 1 || len(a.data) == 0 {
+		return nil, fmt.Errorf("bad shape: %v", a.shape)
+	}
+	result := make([]float64, len(a.data))
+	if _, isInt := a.data[0].(int); isInt {
+		// Integer cumulative sum, then conversion to float.
+		sum := 0
+		for i, v := range a.data {
+			n, ok := v.(int)
+			if !ok {
+				return nil, fmt.Errorf("mixed types: %T", v)
+			}
+			sum += n
+			result[i] = float64(sum)
+		}
+	} else {
+		for i, v := range a.data {
+			f, err := pyFloat(v)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = f
+		}
+		cumsum(result)
+	}
+	last := result[len(result)-1]
+	for i := range result {
+		result[i] /= last
+	}
+	return result, nil
+}
+
+// Returns the nucleotide index of a dict key.
+func issNuc(v any) (int, error) {
+	s, ok := tolist(v).(string)
+	if !ok {
+		return 0, fmt.Errorf("bad nucleotide: %v", v)
+	}
+	i, ok := issNtoi[s]
+	if !ok {
+		return 0, fmt.Errorf("bad nucleotide: %q", s)
+	}
+	return i, nil
+}
+
+// Returns the Python equivalent of zip(*v), for a sequence of sequences.
+func pyZip(v any) ([][]any, error) {
+	rows, err := pyItems(v)
+	if err != nil {
+		return nil, err
+	}
+	var cols [][]any
+	n := -1
+	for _, r := range rows {
+		items, err := pyItems(r)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, items)
+		if n == -1 || len(items) < n {
+			n = len(items)
+		}
+	}
+	result := make([][]any, max(n, 0))
+	for i := range result {
+		for _, c := range cols {
+			result[i] = append(result[i], c[i])
+		}
+	}
+	return result, nil
+}
+
+// Returns the elements of a Python sequence.
+func pyItems(v any) ([]any, error) {
+	switch v := v.(type) {
+	case *pyList:
+		return v.items, nil
+	case pyTuple:
+		return v, nil
+	case *ndarray:
+		return v.items()
+	}
+	return nil, fmt.Errorf("not a sequence: %T", v)
+}
+
+// Returns a Python number as a float.
+func pyFloat(v any) (float64, error) {
+	switch v := tolist(v).(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+// Replaces a with its cumulative sum.
+func cumsum(a []float64) {
+	for i := 1; i < len(a); i++ {
+		a[i] += a[i-1]
+	}
+}
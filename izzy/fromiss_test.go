@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fluhus/izzy/model"
+)
+
+// The test file has the layout of InSilicoSeq's models. It was created
+// with pickles that mimic numpy's format, using pickle protocols 2 to 5.
+// TestIssToModel_numpy checks it against the output of
+// testdata/make_iss_tiny.py, which uses numpy.
+const issTestFile = "testdata/iss_tiny.npz"
+
+func TestIssToModel(t *testing.T) {
+	arrays, err := readNPZ(issTestFile)
+	if err != nil {
+		t.Fatalf("readNPZ(%q) failed: %v", issTestFile, err)
+	}
+	m, err := issToModel(arrays)
+	if err != nil {
+		t.Fatalf("issToModel(...) failed: %v", err)
+	}
+
+	tests := []struct {
+		key  string
+		want any
+	}{
+		{"name", "kde"},
+		{"readLen", 3},
+		{"insertLen", []any{0.0, 0.25, 0.5, 1.0}},
+		{"meanCountForward", []float64{0.75, 1}},
+		{"qualityHistReverse", []any{
+			[]any{
+				[]any{0.1, 0.2, 1.0}, []any{0.1, 0.2, 1.0}, []any{0.1, 0.2, 1.0}},
+			[]any{
+				[]any{0.5, 1.0}, []any{0.5, 1.0}, []any{0.5, 1.0}},
+		}},
+		{"substChoicesForward", [][4][]float64{
+			{{0, 0.1, 0.4, 1}, {0.1, 0.1, 0.4, 1},
+				{0.1, 0.4, 0.4, 1}, {0.1, 0.4, 1, 1}},
+			{{0, 0.2 / 1.1, 0.5 / 1.1, 1}, {0.2 / 1.1, 0.2 / 1.1, 0.5 / 1.1, 1},
+				{0, 0, 1, 1}, // All zeros.
+				{0.2 / 1.1, 0.5 / 1.1, 1, 1}},
+			{{0, 0.25, 0.5, 1}, {0.25, 0.25, 0.5, 1},
+				{0.25, 0.5, 0.5, 1}, {0.25, 0.5, 1, 1}},
+		}},
+		{"insReverse", [][4]any{
+			{0.001, 0.002, 0.0, 1e-5},
+			{0.002, 0.002, 0.0, 1e-5},
+			{0.003, 0.002, 0.0, 1e-5},
+		}},
+	}
+	for _, test := range tests {
+		if got := m[test.key]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s=%v, want %v", test.key, got, test.want)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "model.json")
+	if err := writeJSON(file, m); err != nil {
+		t.Fatalf("writeJSON(...) failed: %v", err)
+	}
+	if _, err := model.Load(file); err != nil {
+		t.Fatalf("Load(...) failed: %v", err)
+	}
+}
+
+// Converts a model written by numpy, and compares the result with
+// fromiss.py and with the test file. Skipped if numpy is not available.
+func TestIssToModel_numpy(t *testing.T) {
+	if err := exec.Command("python3", "-c", "import numpy").Run(); err != nil {
+		t.Skip("numpy is not available:", err)
+	}
+	dir := t.TempDir()
+	npz := filepath.Join(dir, "iss.npz")
+	pyJSON := filepath.Join(dir, "iss.json")
+	for _, args := range [][]string{
+		{"testdata/make_iss_tiny.py", npz},
+		{"fromiss.py", "-i", npz, "-o", pyJSON},
+	} {
+		if out, err := exec.Command("python3", args...).CombinedOutput(); err != nil {
+			t.Fatalf("python3 %v failed: %v\n%s", args, err, out)
+		}
+	}
+
+	data, err := os.ReadFile(pyJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want any
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatalf("Unmarshal(%q) failed: %v", pyJSON, err)
+	}
+	for _, file := range []string{npz, issTestFile} {
+		arrays, err := readNPZ(file)
+		if err != nil {
+			t.Fatalf("readNPZ(%q) failed: %v", file, err)
+		}
+		m, err := issToModel(arrays)
+		if err != nil {
+			t.Fatalf("issToModel(%q) failed: %v", file, err)
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got any
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("issToModel(%q)=%v, want %v", file, got, want)
+		}
+	}
+}
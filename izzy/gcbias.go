@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fluhus/gostuff/csvdec"
+	"github.com/fluhus/izzy/model"
+)
+
+// Returns the GC bias curve of the given spec, or loads it from a table
+// file if one exists with that name.
+func loadGCBias(spec string) (*model.GCCurve, error) {
+	if _, err := os.Stat(spec); err != nil {
+		return model.ParseGCCurve(spec)
+	}
+	return readGCTable(spec)
+}
+
+// Reads a TSV file of GC fractions and their relative coverage.
+func readGCTable(file string) (*model.GCCurve, error) {
+	type entry struct {
+		GC     float64
+		Weight float64
+	}
+	var gc, weight []float64
+	for row, err := range csvdec.File[entry](file, toTSV) {
+		if err != nil {
+			return nil, err
+		}
+		gc = append(gc, row.GC)
+		weight = append(weight, row.Weight)
+	}
+	c, err := model.NewGCCurve(file, gc, weight)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadGCBias(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gc.tsv")
+	data := "# GC\tweight\n0.3\t2\n0.5\t4\n0.7\t1\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec     string
+		gc, want float64
+	}{
+		{file, 0.4, 0.75},
+		{file, 0.9, 0.25},
+		{"gaussian(center=0.4)", 0.4, 1},
+	}
+	for _, test := range tests {
+		c, err := loadGCBias(test.spec)
+		if err != nil {
+			t.Fatalf("loadGCBias(%q) failed: %v", test.spec, err)
+		}
+		if got := c.Weight(test.gc); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("loadGCBias(%q).Weight(%v)=%v, want %v",
+				test.spec, test.gc, got, test.want)
+		}
+	}
+
+	if err := os.WriteFile(file, []byte("0.5\t1\n0.4\t1\n"),
+		0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range []string{file, "gc.tsv", "gaussian(width=-1)"} {
+		if _, err := loadGCBias(spec); err == nil {
+			t.Errorf("loadGCBias(%q) succeeded, want error", spec)
+		}
+	}
+}
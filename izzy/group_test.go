@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestGroupName(t *testing.T) {
+	defer func(by string, strip *regexp.Regexp) {
+		*groupBy, *fileStrip = by, strip
+	}(*groupBy, *fileStrip)
+
+	tests := []struct {
+		by      string
+		strip   *regexp.Regexp
+		grouper *regexp.Regexp
+		want    string
+	}{
+		{groupByName, nil, nil, "Species_1_contig_2 desc"},
+		{groupByName, nil, regexp.MustCompile(`Species_\d+`), "Species_1"},
+		{groupByFile, nil, nil, "genome1.fa.gz"},
+		{groupByFile, regexp.MustCompile(`\.fa(sta)?(\.gz)?$`), nil, "genome1"},
+		{groupByPath, regexp.MustCompile(`\.fa(sta)?(\.gz)?$`),
+			regexp.MustCompile(`Species_\d+`), "data/genome1.fa.gz"},
+	}
+	for _, test := range tests {
+		*groupBy, *fileStrip = test.by, test.strip
+		got, _ := groupName("data/genome1.fa.gz",
+			[]byte("Species_1_contig_2 desc"), test.grouper)
+		if got != test.want {
+			t.Errorf("groupName(%q,%v,%v)=%q, want %q",
+				test.by, test.strip, test.grouper, got, test.want)
+		}
+	}
+}
+
+func TestGroupName_map(t *testing.T) {
+	defer func(by, policy string, g map[string]string) {
+		*groupBy, *unmapped, groups = by, policy, g
+	}(*groupBy, *unmapped, groups)
+	*groupBy = groupByMap
+	groups = map[string]string{"c1": "g1", "c2": "g1"}
+
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+		wantOK bool
+	}{
+		{"c1 desc", unmappedDrop, "g1", true},
+		{"c2", unmappedError, "g1", true},
+		{"c3 desc", unmappedOwn, "c3", true},
+		{"c3 desc", unmappedDrop, "c3", false},
+		{"c3 desc", unmappedError, "c3", false},
+	}
+	for _, test := range tests {
+		*unmapped = test.policy
+		got, ok := groupName("a.fa", []byte(test.name), nil)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("groupName(%q) with %s=(%q,%v), want (%q,%v)",
+				test.name, test.policy, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestReadGroupMap(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"c1\tg1\nc2\tg1\nc3\tg2\n",
+			map[string]string{"c1": "g1", "c2": "g1", "c3": "g2"}, false},
+		{"c1\tg1\nc1\tg1\n", map[string]string{"c1": "g1"}, false},
+		{"c1\tg1\nc1\tg2\n", nil, true},
+		{"c1\t\n", nil, true},
+		{"", nil, true},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "map.tsv")
+		if err := os.WriteFile(file, []byte(test.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readGroupMap(file)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("readGroupMap(%q) error=%v, want error=%v",
+				test.data, err, test.wantErr)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("readGroupMap(%q)=%v, want %v", test.data, got, test.want)
+		}
+	}
+}
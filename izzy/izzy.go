@@ -12,12 +12,10 @@ import (
 	"regexp"
 
 	"github.com/fluhus/biostuff/formats/fasta"
-	"github.com/fluhus/biostuff/formats/fastq"
 	"github.com/fluhus/biostuff/sequtil"
 	"github.com/fluhus/gostuff/aio"
 	"github.com/fluhus/gostuff/csvdec"
 	"github.com/fluhus/gostuff/flagx"
-	"github.com/fluhus/gostuff/gnum"
 	"github.com/fluhus/gostuff/ptimer"
 	"github.com/fluhus/gostuff/snm"
 	"github.com/fluhus/izzy/abdist"
@@ -25,19 +23,40 @@ import (
 	"golang.org/x/exp/maps"
 )
 
-// BUG(amit): Add flag for grouping by file name.
-
 var (
 	inGlob       = flag.String("i", "", "Input file glob pattern")
 	outFile      = flag.String("o", "", "Output file prefix")
 	nReads       = flag.Int("n", 0, "Number of reads")
+	coverage     = flag.Float64("c", 0, "Mean coverage per genome, instead of -n")
+	coverageMode = flagx.OneOf("coverage-mode", coverageAbundance, "How coverage is divided between genomes with -c, one of [abundance uniform]", coverageAbundance, coverageUniform)
+	totalBases   = flag.String("bases", "", "Total number of read bases, like 5G, instead of -n")
 	nGenomes     = flag.Int("u", 0, "Number of genomes to simulate from (default: all)")
-	modelName    = flag.String("m", "", "Model name, one of "+fmtKeys(modelNameToModel))
-	distName     = flag.String("d", "lognormal", "Abundance distribution, one of "+fmtKeys(distNameToDist))
+	modelName    = flag.String("m", "", "Model name, one of "+fmtKeys(modelNameToModel)+", or a JSON model file")
+	distName     = flag.String("d", "lognormal", "Abundance distribution, like lognormal or lognormal(sigma=2), one of "+fmt.Sprint(abdist.Names()))
 	ignoreLength = flag.Bool("l", false, "Ignore genome lengths for read counts")
 	singleOutput = flag.Bool("s", false, "Output one file instead of two")
 	abndFile     = flag.String("a", "", "Use abundances from a file")
+	abndFormat   = flagx.OneOf("abundance-format", abndAuto, "Format of the abundance file, one of [auto relative counts coverage cami metaphlan bracken kreport]", abndAuto, abndRelative, abndCounts, abndCoverage, abndCAMI, abndMetaPhlAn, abndBracken, abndKreport)
 	re           = flagx.Regexp("g", regexp.MustCompile(".*"), "Pattern by which to group contigs of the same species")
+	groupBy      = flagx.OneOf("group-by", groupByName, "What to group contigs by, one of [name file path map]", groupByName, groupByFile, groupByPath, groupByMap)
+	fileStrip    = flagx.Regexp("file-strip", nil, "Pattern to remove from file names when grouping by file (default: none)")
+	groupMapFile = flag.String("group-map", "", "TSV file mapping contig names to groups, for grouping by map")
+	unmapped     = flagx.OneOf("unmapped", unmappedError, "What to do with contigs that are not in the group map, one of [drop own error]", unmappedDrop, unmappedOwn, unmappedError)
+	threads      = flag.Int("t", 1, "Number of threads")
+	outFormat    = flagx.OneOf("f", "fastq", "Output format, one of [fastq sam bam]", "fastq", "sam", "bam")
+	truthOutput  = flag.Bool("truth", false, "Write a per-read ground truth table")
+	truthBase    = flagx.OneOf("truth-base", "1", "Coordinates of the ground truth, 1 for 1-based inclusive (like SAM) or 0 for 0-based end-exclusive (like BED)", "0", "1")
+	seed         = flag.Uint64("seed", 0, "Random seed, for reproducible runs (default: random)")
+	singleEnd    = flag.Bool("single-end", false, "Simulate single-end reads instead of pairs")
+	ambigMode    = flagx.OneOf("nmode", ambigDrop, "How to handle non-ACGT bases in the input, one of [drop split resolve keep]", ambigDrop, ambigSplit, ambigResolve, ambigKeep)
+	r2FwdIndels  = flag.Bool("r2-forward-indels", false, "Use the forward indel profiles for R2")
+	allocMode    = flagx.OneOf("alloc", allocRound, "How to allocate reads to sequences, one of [round exact]", allocRound, allocExact)
+	shuffle      = flag.Bool("shuffle", false, "Shuffle the output reads, keeping pairs together")
+	circularAll  = flag.Bool("circular", false, "Treat all contigs as circular")
+	circularRE   = flagx.Regexp("circular-re", nil, "Treat contigs whose names match this pattern as circular")
+	gcBias       = flag.String("gc-bias", "", "GC coverage bias, like gaussian or gaussian(center=0.4,width=0.1,floor=0.05), or a TSV file of GC fractions and relative coverage (default: none)")
+	taxidMapFile = flag.String("taxid-map", "", "TSV file mapping groups to NCBI taxon IDs, for a CAMI profile")
+	taxdumpDir   = flag.String("taxdump", "", "Directory of an NCBI taxdump (nodes.dmp and names.dmp), for a CAMI profile")
 
 	modelNameToModel = map[string]*model.Model{
 		"basic":   model.BasicModel,
@@ -46,23 +65,45 @@ var (
 		"miseq":   model.MiSeqModel,
 		"novaseq": model.NovaSeqModel,
 	}
-	distNameToDist = map[string]func(int, int) []float64{
-		"lognormal":   abdist.LogNormal,
-		"exponential": abdist.Exponential,
-		"halfnormal":  abdist.HalfNormal,
-	}
 
-	rng     = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	rng     *rand.Rand        // Initialized from the seed flag.
+	dist    *abdist.Dist      // Parsed from the distribution flag.
+	groups  map[string]string // Contig name to group, for grouping by map.
 	inFiles []string
 	version = "development" // Populated with build flags.
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "model" {
+		die(modelMain(os.Args[2:]))
+		return
+	}
+
 	flag.Usage = printUsage
 	flag.Parse()
 	die(checkArgs())
 
-	m := modelNameToModel[*modelName]
+	m, err := loadModel(*modelName)
+	die(err)
+	opts := model.Options{R2ForwardIndels: *r2FwdIndels}
+	if *gcBias != "" {
+		opts.GCBias, err = loadGCBias(*gcBias)
+		die(err)
+		fmt.Println("GC bias:", opts.GCBias.Name)
+	}
+
+	if !isFlagSet("seed") {
+		*seed = rand.Uint64()
+	}
+	fmt.Println("Seed:", *seed)
+	die(writeSeed(*seed, *outFile+"_seed.txt"))
+	rng = rand.New(rand.NewPCG(*seed, 0))
+
+	if *groupBy == groupByMap {
+		fmt.Println("Reading group map")
+		groups, err = readGroupMap(*groupMapFile)
+		die(err)
+	}
 
 	fmt.Println("Reading sequence lengths")
 	lens, err := readSequenceLens(inFiles, *re)
@@ -82,74 +123,72 @@ func main() {
 	}
 
 	var groupRatios map[string]float64
+	var groupReads map[string]float64 // Reads set by the abundance file.
+	weighted := !*ignoreLength        // Whether groupRatios are length-weighted.
 	if *abndFile != "" {
 		fmt.Println("Loading abundance from file")
-		groupRatios, err = readAbundanceFile(*abndFile, groupLens)
+		groupRatios, groupReads, weighted, err = readAbundanceFile(*abndFile, *abndFormat,
+			groupLens, m.ReadLen)
 	} else {
 		fmt.Println("Creating abundance distribution")
 		groupRatios, err = createAbundance(groupLens, *outFile+"_abundance.tsv")
 	}
 	die(err)
 
-	fmt.Println("Generating reads")
-	var fout1, fout2 *aio.Writer
-	if *singleOutput {
-		fout1, err = aio.Create(*outFile + ".fastq.gz")
+	var taxids map[string]int
+	var tax *taxonomy
+	if *taxidMapFile != "" {
+		fmt.Println("Loading taxonomy")
+		taxids, err = readTaxidMap(*taxidMapFile)
 		die(err)
-		fout2 = fout1
-	} else {
-		fout1, err = aio.Create(*outFile + "_R1.fastq.gz")
+		tax, err = loadTaxonomy(*taxdumpDir, maps.Values(taxids))
 		die(err)
-		fout2, err = aio.Create(*outFile + "_R2.fastq.gz")
+		// Fail before simulating if a group has no taxon.
+		_, err = goldStandardProfile(groupRatios, taxids, tax)
 		die(err)
 	}
 
-	pt := ptimer.NewMessage("{} reads generated")
-	for _, f := range inFiles {
-		for fa, err := range fasta.File(f) {
-			die(err)
-			if !isNucs(fa.Sequence) {
-				continue
-			}
-			gl := lens[0]
-			lens = lens[1:]
-			groupReads := groupRatios[gl.g] * float64(*nReads)
-			seqRatio := float64(gl.n) / float64(groupLens[gl.g])
-
-			// Convert fraction to whole number.
-			nreadsf := groupReads * seqRatio
-			nreads := int(math.Floor(nreadsf))
-			if rand.Float64() < nreadsf-math.Floor(nreadsf) {
-				nreads++
-			}
+	*nReads, err = fragmentCount(m, groupLens, groupRatios,
+		sortedSum(groupReads))
+	die(err)
+
+	var counts, excluded []int
+	switch {
+	case groupReads != nil: // Used as they are, regardless of -alloc.
+		counts, excluded, err = groupCounts(lens, groupReads, minSeqLen(m))
+		die(err)
+		*nReads = sum(counts)
+	case *allocMode == allocExact:
+		counts, excluded, err = multinomialCounts(lens, groupRatios, *nReads,
+			minSeqLen(m), rng)
+		die(err)
+	}
+	if len(excluded) > 0 {
+		fmt.Println(excludedWarning(lens, excluded, minSeqLen(m)))
+	}
+	fmt.Println("Simulating", readsPerFragment()**nReads, "reads")
+
+	fmt.Println("Generating reads")
+	fragments, err := simulate(*outFile, m, opts, lens, counts, groupLens,
+		groupRatios)
+	die(err)
+
+	fmt.Println("Writing summary")
+	rows := summaryRows(groupLens, groupRatios, weighted, fragments,
+		m.ReadLen)
+	die(writeSummary(*outFile+"_summary.tsv", rows))
 
-			simulateReads(fa.Sequence, m, nreads,
-				func(fwd, bwd *fastq.Fastq) error {
-					if len(fwd.Sequence) != m.ReadLen {
-						return fmt.Errorf("bad read length: %d, want %d",
-							len(fwd.Sequence), m.ReadLen)
-					}
-					if len(bwd.Sequence) != m.ReadLen {
-						return fmt.Errorf("bad read length: %d, want %d",
-							len(bwd.Sequence), m.ReadLen)
-					}
-					fwd.Name = []byte(fmt.Sprintf(
-						"%d.%s.%s", pt.N+1, fwd.Name, fa.Name))
-					bwd.Name = []byte(fmt.Sprintf(
-						"%d.%s.%s", pt.N+2, bwd.Name, fa.Name))
-					txt, _ := fwd.MarshalText()
-					fout1.Write(txt)
-					txt, _ = bwd.MarshalText()
-					fout2.Write(txt)
-					pt.Inc()
-					pt.Inc() // Each pair is 2 reads.
-					return nil
-				})
+	if tax != nil {
+		fmt.Println("Writing CAMI profile")
+		abnd := map[string]float64{}
+		for _, r := range rows {
+			abnd[r.group] = r.abundance
 		}
+		profile, err := goldStandardProfile(abnd, taxids, tax)
+		die(err)
+		die(writeCAMIProfile(*outFile+"_cami_profile.tsv",
+			filepath.Base(*outFile), profile))
 	}
-	fout1.Close()
-	fout2.Close()
-	pt.Done()
 }
 
 func checkArgs() error {
@@ -160,10 +199,32 @@ func checkArgs() error {
 	if *outFile == "" {
 		return fmt.Errorf("no output file")
 	}
-	if *nReads < 1 {
+	if n := numSizeFlags(); n > 1 || (n == 0 && *abndFile == "") {
+		return fmt.Errorf("need exactly one of -n, -c and -bases")
+	}
+	if *nReads < 0 {
 		return fmt.Errorf("number of reads needs to be at least 1")
 	}
-	*nReads = (*nReads + 1) / 2 // We will create nreads/2 pairs.
+	if *coverage < 0 || math.IsNaN(*coverage) || math.IsInf(*coverage, 0) {
+		return fmt.Errorf("bad coverage: %v", *coverage)
+	}
+	if *totalBases != "" {
+		if _, err := parseBases(*totalBases); err != nil {
+			return err
+		}
+	}
+	if *coverageMode == coverageUniform {
+		if *coverage == 0 {
+			return fmt.Errorf("-coverage-mode %s needs -c", coverageUniform)
+		}
+		if *abndFile != "" || *ignoreLength || isFlagSet("d") {
+			return fmt.Errorf("-coverage-mode %s cannot be used with -a, "+
+				"-d or -l", coverageUniform)
+		}
+	}
+	if *threads < 1 {
+		return fmt.Errorf("bad number of threads: %d", *threads)
+	}
 	if *nGenomes < 0 {
 		return fmt.Errorf("bad number of genomes: %d", *nGenomes)
 	}
@@ -175,56 +236,173 @@ func checkArgs() error {
 	if len(files) == 0 {
 		return fmt.Errorf("found 0 input files")
 	}
-	if modelNameToModel[*modelName] == nil {
-		return fmt.Errorf("bad model name: %q, need one of %v",
-			*modelName, fmtKeys(modelNameToModel))
+	if *modelName == "" {
+		return fmt.Errorf("no model")
 	}
-	if distNameToDist[*distName] == nil {
-		return fmt.Errorf("bad distribution name: %q, need one of %v",
-			*distName, fmtKeys(distNameToDist))
+	if (*groupBy == groupByMap) != (*groupMapFile != "") {
+		return fmt.Errorf("-group-map should be given if and only if " +
+			"grouping by map")
+	}
+	if (*taxidMapFile != "") != (*taxdumpDir != "") {
+		return fmt.Errorf("-taxid-map and -taxdump should be given together")
+	}
+	if dist, err = abdist.Parse(*distName); err != nil {
+		return err
+	}
+	if *coverageMode == coverageUniform {
+		dist, _ = abdist.Parse("uniform")
 	}
 	return nil
 }
 
+// Returns whether the flag with the given name was given on the command
+// line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Returns the built-in model with the given name, or loads it from a file
+// if no such model exists.
+func loadModel(name string) (*model.Model, error) {
+	if m := modelNameToModel[name]; m != nil {
+		return m, nil
+	}
+	if _, err := os.Stat(name); err != nil {
+		return nil, fmt.Errorf("bad model name: %q, need one of %v "+
+			"or a JSON file", name, fmtKeys(modelNameToModel))
+	}
+	fmt.Println("Loading model from file")
+	return model.Load(name)
+}
+
 func readSequenceLens(files []string, grouper *regexp.Regexp) ([]lenGroup, error) {
 	pt := ptimer.NewMessage("{} sequences read")
 	var result []lenGroup
+	ambig := &ambigStats{}
+	ref, ncircular, nunmapped := 0, 0, 0
 	for _, f := range files {
 		for fa, err := range fasta.File(f) {
 			if err != nil {
 				return nil, err
 			}
-			g := string(fa.Name)
-			if grouper != nil {
-				g = grouper.FindString(g)
+			if *groupBy == groupByMap && groups[samRefName(fa.Name)] == "" {
+				if *unmapped == unmappedError {
+					return nil, fmt.Errorf("contig %q is not in the group map",
+						samRefName(fa.Name))
+				}
+				nunmapped++
+			}
+			g, ok := groupName(f, fa.Name, grouper)
+			if !ok {
+				pt.Inc()
+				continue
 			}
-			if isNucs(fa.Sequence) {
-				result = append(result, lenGroup{g, len(fa.Sequence)})
+			pieces := contigPieces(fa.Sequence, *ambigMode)
+			ambig.add(fa.Sequence, pieces)
+			for _, p := range pieces {
+				result = append(result, lenGroup{g, p.end - p.start,
+					samRefName(fa.Name), ref, len(fa.Sequence)})
+			}
+			if len(pieces) > 0 {
+				ref++
+			}
+			if isWholeCircular(fa, pieces) {
+				ncircular++
 			}
 			pt.Inc()
 		}
 	}
 	pt.Done()
+	fmt.Println(ambig.report(*ambigMode))
+	if ncircular > 0 {
+		fmt.Println(ncircular, "circular contigs")
+	}
+	if nunmapped > 0 {
+		fmt.Printf("%d contigs are not in the group map (-unmapped %s)\n",
+			nunmapped, *unmapped)
+	}
 	return result, nil
 }
 
-type lenGroup struct {
-	g string // Group name
-	n int    // Length of sequence
+// Modes of grouping contigs.
+const (
+	groupByName = "name" // Fasta name, matched against the -g pattern
+	groupByFile = "file" // File base name, without the -file-strip pattern
+	groupByPath = "path" // File path as given
+	groupByMap  = "map"  // Contig name, looked up in the -group-map file
+)
+
+// Policies for contigs that are not in the group map.
+const (
+	unmappedDrop  = "drop"  // Do not simulate from them
+	unmappedOwn   = "own"   // Each one is its own group
+	unmappedError = "error" // Fail
+)
+
+// Returns the group name of a contig from the given file. Returns false if
+// the contig should not be used, because it is not in the group map.
+func groupName(file string, name []byte, grouper *regexp.Regexp,
+) (string, bool) {
+	switch *groupBy {
+	case groupByFile:
+		g := filepath.Base(file)
+		if *fileStrip != nil {
+			g = (*fileStrip).ReplaceAllString(g, "")
+		}
+		return g, true
+	case groupByPath:
+		return file, true
+	case groupByMap:
+		if g := groups[samRefName(name)]; g != "" {
+			return g, true
+		}
+		return samRefName(name), *unmapped == unmappedOwn
+	}
+	g := string(name)
+	if grouper != nil {
+		g = grouper.FindString(g)
+	}
+	return g, true
 }
 
-func simulateReads(seq []byte, m *model.Model, n int,
-	forEach func(fwd, bwd *fastq.Fastq) error) error {
-	for range n {
-		fwd, bwd := m.SimulateRead(seq, rng)
-		if fwd == nil { // Sequence is too short.
-			continue
+// Reads a TSV file of contig names and their groups.
+func readGroupMap(file string) (map[string]string, error) {
+	type entry struct {
+		Contig string
+		Group  string
+	}
+	result := map[string]string{}
+	for row, err := range csvdec.File[entry](file, toTSV) {
+		if err != nil {
+			return nil, err
 		}
-		if err := forEach(fwd, bwd); err != nil {
-			return err
+		if row.Group == "" {
+			return nil, fmt.Errorf("contig %q has an empty group", row.Contig)
 		}
+		if g, ok := result[row.Contig]; ok && g != row.Group {
+			return nil, fmt.Errorf("contig %q is mapped to both %q and %q",
+				row.Contig, g, row.Group)
+		}
+		result[row.Contig] = row.Group
 	}
-	return nil
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no contigs in group map")
+	}
+	return result, nil
+}
+
+type lenGroup struct {
+	g         string // Group name
+	n         int    // Length of sequence
+	name      string // Contig name, as in SAM output
+	ref       int    // Index of the contig among the used contigs
+	contigLen int    // Length of the contig, which may be split into several sequences
 }
 
 func createAbundance(groupLens map[string]int, file string) (map[string]float64, error) {
@@ -234,9 +412,13 @@ func createAbundance(groupLens map[string]int, file string) (map[string]float64,
 	}
 	defer fout.Close()
 
-	abnd := distNameToDist[*distName](len(groupLens), *nGenomes)
+	if _, err := fmt.Fprintf(fout, "# distribution: %v\n", dist); err != nil {
+		return nil, err
+	}
+	abnd := dist.Abundances(len(groupLens), *nGenomes, rng)
 	groupRatios := map[string]float64{}
-	for k := range groupLens {
+	// Sorted for reproducibility.
+	for _, k := range snm.Sorted(maps.Keys(groupLens)) {
 		ab := abnd[0]
 		abnd = abnd[1:]
 		if ab == 0 {
@@ -250,47 +432,24 @@ func createAbundance(groupLens map[string]int, file string) (map[string]float64,
 			groupRatios[k] *= float64(groupLens[k])
 		}
 	}
-	sum := gnum.Sum(maps.Values(groupRatios))
+	sum := sortedSum(groupRatios)
 	for k := range groupRatios {
 		groupRatios[k] /= sum
 	}
 	return groupRatios, nil
 }
 
-func readAbundanceFile(file string, groupLens map[string]int) (map[string]float64, error) {
-	type entry struct {
-		Name string
-		Abnd float64
-	}
-	result := map[string]float64{}
-	for row, err := range csvdec.File[entry](file, toTSV) {
-		if err != nil {
-			return nil, err
-		}
-		if _, ok := result[row.Name]; ok {
-			return nil, fmt.Errorf("duplicate name: %s, values: %v %v",
-				row.Name, result[row.Name], row.Abnd)
-		}
-		if row.Abnd <= 0 {
-			return nil, fmt.Errorf("bad abundance value: %v", row.Abnd)
-		}
-		ln, ok := groupLens[row.Name]
-		if !ok {
-			return nil, fmt.Errorf("unrecognized name: %s", row.Name)
-		}
-		result[row.Name] = row.Abnd
-		if !*ignoreLength {
-			result[row.Name] *= float64(ln)
-		}
-	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no abundances in file")
+// Writes the random seed to a file, so that the run can be repeated.
+func writeSeed(seed uint64, file string) error {
+	fout, err := aio.Create(file)
+	if err != nil {
+		return err
 	}
-	sum := gnum.Sum(maps.Values(result))
-	for k := range result {
-		result[k] /= sum
+	if _, err := fmt.Fprintln(fout, seed); err != nil {
+		fout.Close()
+		return err
 	}
-	return result, nil
+	return fout.Close()
 }
 
 // Checks whether a sequence is made only of ATCG.
@@ -303,6 +462,16 @@ func isNucs(seq []byte) bool {
 	return true
 }
 
+// Sums the values of m in the order of its keys, so that the result
+// does not depend on map iteration order.
+func sortedSum(m map[string]float64) float64 {
+	sum := 0.0
+	for _, k := range snm.Sorted(maps.Keys(m)) {
+		sum += m[k]
+	}
+	return sum
+}
+
 // Prints the error and exits if the error is non-nil.
 func die(err error) {
 	if err != nil {
@@ -313,6 +482,7 @@ func die(err error) {
 
 func toTSV(r *csv.Reader) {
 	r.Comma = '\t'
+	r.Comment = '#'
 }
 
 // Returns a string representation of a map's keys.
@@ -323,7 +493,8 @@ func fmtKeys[V any](m map[string]V) string {
 // Override for [flag.Usage].
 func printUsage() {
 	fmt.Fprintf(flag.CommandLine.Output(),
-		"Izzy: a large-scale metagenomic read simulator (version %s)\n\n",
+		"Izzy: a large-scale metagenomic read simulator (version %s)\n\n"+
+			"For model utilities, run: izzy model\n\n",
 		version)
 	flag.PrintDefaults()
 }
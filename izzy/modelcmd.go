@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/fasta"
+	"github.com/fluhus/biostuff/formats/sam"
+	"github.com/fluhus/gostuff/aio"
+	"github.com/fluhus/izzy/model"
+)
+
+// Model subcommands, by name.
+var modelCommands = map[string]func(args []string) error{
+	"import": modelImport,
+	"train":  modelTrain,
+}
+
+// Runs a model subcommand, as in "izzy model import ...".
+func modelMain(args []string) error {
+	if len(args) == 0 || modelCommands[args[0]] == nil {
+		return fmt.Errorf("usage: izzy model COMMAND, "+
+			"where COMMAND is one of %v", fmtKeys(modelCommands))
+	}
+	return modelCommands[args[0]](args[1:])
+}
+
+// Converts an InSilicoSeq npz model to izzy's JSON format.
+func modelImport(args []string) error {
+	fs := flag.NewFlagSet("izzy model import", flag.ExitOnError)
+	in := fs.String("i", "", "Input InSilicoSeq model file (npz)")
+	out := fs.String("o", "", "Output izzy model file (json)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(),
+			"Converts an InSilicoSeq model to an izzy model.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *in == "" || *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	arrays, err := readNPZ(*in)
+	if err != nil {
+		return err
+	}
+	m, err := issToModel(arrays)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(*out, m); err != nil {
+		return err
+	}
+	if _, err := model.Load(*out); err != nil {
+		return fmt.Errorf("converted model is invalid: %w", err)
+	}
+	return nil
+}
+
+// Creates a model from real reads aligned to a reference.
+func modelTrain(args []string) error {
+	fs := flag.NewFlagSet("izzy model train", flag.ExitOnError)
+	in := fs.String("i", "", "Input alignments of paired reads (sam or bam)")
+	refFile := fs.String("r", "", "Reference sequences the reads were aligned to (fasta)")
+	out := fs.String("o", "", "Output izzy model file (json)")
+	name := fs.String("name", "", "Model name (default: output file name)")
+	readLen := fs.Int("l", 0, "Read length (default: most common)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(),
+			"Creates an izzy model from real paired reads, aligned to a "+
+				"reference.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *in == "" || *refFile == "" || *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *readLen < 0 {
+		return fmt.Errorf("bad read length: %d", *readLen)
+	}
+	if *name == "" {
+		*name = strings.TrimSuffix(filepath.Base(*out), ".json")
+	}
+
+	fmt.Println("Reading reference")
+	refs := map[string][]byte{}
+	for fa, err := range fasta.File(*refFile) {
+		if err != nil {
+			return err
+		}
+		refs[samRefName(fa.Name)] = fa.Sequence
+	}
+
+	fmt.Println("Reading alignments")
+	reads := sam.File(*in)
+	if strings.HasSuffix(*in, ".bam") {
+		reads = bamFile(*in)
+	}
+	st, err := trainFromSAM(reads, refs, *readLen)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Used", st)
+	m, err := st.model(*name)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(*out, m); err != nil {
+		return err
+	}
+	if _, err := model.Load(*out); err != nil {
+		return fmt.Errorf("trained model is invalid: %w", err)
+	}
+	return nil
+}
+
+// Writes v to a file as compact JSON.
+func writeJSON(file string, v any) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fout, err := aio.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := fout.Write(j); err != nil {
+		fout.Close()
+		return err
+	}
+	return fout.Close()
+}
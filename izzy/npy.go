@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reading of numpy's npy and npz files.
+// Format reference: https://numpy.org/doc/stable/reference/generated/numpy.lib.format.html
+
+// A numpy array, flattened in C order.
+type ndarray struct {
+	shape []int
+	data  []any
+}
+
+// Returns the number of elements in the array.
+func (a *ndarray) size() int {
+	n := 1
+	for _, s := range a.shape {
+		n *= s
+	}
+	return n
+}
+
+// Returns the array as nested slices, like numpy's tolist.
+// Zero-dimensional arrays return their single element.
+// Unlike numpy, arrays nested in object arrays are converted too.
+func (a *ndarray) tolist() any {
+	if len(a.shape) == 0 {
+		return tolist(a.data[0])
+	}
+	var rec func(data []any, shape []int) []any
+	rec = func(data []any, shape []int) []any {
+		result := make([]any, shape[0])
+		if len(shape) == 1 {
+			for i := range result {
+				result[i] = tolist(data[i])
+			}
+			return result
+		}
+		step := len(data) / max(shape[0], 1)
+		for i := range result {
+			result[i] = rec(data[i*step:(i+1)*step], shape[1:])
+		}
+		return result
+	}
+	return rec(a.data, a.shape)
+}
+
+// Returns the first-axis elements of the array, like iterating over it
+// in Python.
+func (a *ndarray) items() ([]any, error) {
+	if len(a.shape) == 0 {
+		return nil, fmt.Errorf("iteration over a 0-d array")
+	}
+	if len(a.shape) == 1 {
+		return a.data, nil
+	}
+	result := make([]any, a.shape[0])
+	step := len(a.data) / max(a.shape[0], 1)
+	for i := range result {
+		result[i] = &ndarray{a.shape[1:], a.data[i*step : (i+1)*step]}
+	}
+	return result, nil
+}
+
+// Converts Python values to plain Go values, recursively.
+// Lists and tuples become slices and dicts become maps with string keys.
+func tolist(v any) any {
+	switch v := v.(type) {
+	case *ndarray:
+		return v.tolist()
+	case *pyList:
+		return tolistSlice(v.items)
+	case pyTuple:
+		return tolistSlice(v)
+	case *pyDict:
+		m := make(map[string]any, len(v.keys))
+		for i, k := range v.keys {
+			m[fmt.Sprint(k)] = tolist(v.values[i])
+		}
+		return m
+	}
+	return v
+}
+
+// Applies tolist to each element of a slice.
+func tolistSlice(s []any) []any {
+	result := make([]any, len(s))
+	for i := range s {
+		result[i] = tolist(s[i])
+	}
+	return result
+}
+
+// A numpy data type.
+type dtype struct {
+	order byte // '<', '>' or '|'
+	kind  byte // One of "biufUSO"
+	size  int  // Element size in bytes
+}
+
+// Matches a type description like "<f8".
+var dtypeRE = regexp.MustCompile(`^([<>|=])([biufUSO])(\d*)$`)
+
+// Parses a type description, such as "<f8".
+func parseDtype(s string) (*dtype, error) {
+	m := dtypeRE.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("unsupported dtype: %q", s)
+	}
+	dt := &dtype{order: m[1][0], kind: m[2][0]}
+	if dt.order == '=' {
+		dt.order = '<'
+	}
+	if m[3] != "" {
+		n, err := strconv.Atoi(m[3])
+		if err != nil {
+			return nil, err
+		}
+		dt.size = n
+	}
+	if dt.kind == 'U' {
+		dt.size *= 4 // UCS-4 characters.
+	}
+	switch dt.kind {
+	case 'b':
+		dt.size = max(dt.size, 1)
+	case 'i', 'u':
+		if dt.size != 1 && dt.size != 2 && dt.size != 4 && dt.size != 8 {
+			return nil, fmt.Errorf("unsupported dtype: %q", s)
+		}
+	case 'f':
+		if dt.size != 4 && dt.size != 8 {
+			return nil, fmt.Errorf("unsupported dtype: %q", s)
+		}
+	}
+	return dt, nil
+}
+
+// Returns the byte order of the type.
+func (dt *dtype) byteOrder() binary.ByteOrder {
+	if dt.order == '>' {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Decodes a single element.
+func (dt *dtype) decode(b []byte) (any, error) {
+	if len(b) < dt.size {
+		return nil, fmt.Errorf("element has %d bytes, want %d", len(b), dt.size)
+	}
+	bo := dt.byteOrder()
+	switch dt.kind {
+	case 'b':
+		return b[0] != 0, nil
+	case 'i':
+		switch dt.size {
+		case 1:
+			return int(int8(b[0])), nil
+		case 2:
+			return int(int16(bo.Uint16(b))), nil
+		case 4:
+			return int(int32(bo.Uint32(b))), nil
+		case 8:
+			return int(int64(bo.Uint64(b))), nil
+		}
+	case 'u':
+		switch dt.size {
+		case 1:
+			return int(b[0]), nil
+		case 2:
+			return int(bo.Uint16(b)), nil
+		case 4:
+			return int(bo.Uint32(b)), nil
+		case 8:
+			return int(bo.Uint64(b)), nil
+		}
+	case 'f':
+		switch dt.size {
+		case 4:
+			return float64(math.Float32frombits(bo.Uint32(b))), nil
+		case 8:
+			return math.Float64frombits(bo.Uint64(b)), nil
+		}
+	case 'S':
+		return string(bytes.TrimRight(b[:dt.size], "\x00")), nil
+	case 'U':
+		var s []byte
+		for i := 0; i+4 <= dt.size; i += 4 {
+			r := rune(bo.Uint32(b[i:]))
+			if r == 0 {
+				break
+			}
+			s = utf8.AppendRune(s, r)
+		}
+		return string(s), nil
+	}
+	return nil, fmt.Errorf("cannot decode dtype %c%d", dt.kind, dt.size)
+}
+
+// Decodes consecutive elements.
+func (dt *dtype) decodeAll(b []byte) ([]any, error) {
+	if dt.size == 0 {
+		if len(b) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("zero-size dtype with %d bytes", len(b))
+	}
+	if len(b)%dt.size != 0 {
+		return nil, fmt.Errorf("data length %d is not a multiple of %d",
+			len(b), dt.size)
+	}
+	result := make([]any, 0, len(b)/dt.size)
+	for i := 0; i < len(b); i += dt.size {
+		v, err := dt.decode(b[i : i+dt.size])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// Matches the fields of an npy header.
+var (
+	npyDescrRE   = regexp.MustCompile(`'descr'\s*:\s*'([^']*)'`)
+	npyFortranRE = regexp.MustCompile(`'fortran_order'\s*:\s*(True|False)`)
+	npyShapeRE   = regexp.MustCompile(`'shape'\s*:\s*\(([^)]*)\)`)
+)
+
+// Reads a single array in npy format.
+func readNPY(r io.Reader) (*ndarray, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic[:6]) != "\x93NUMPY" {
+		return nil, fmt.Errorf("not an npy file")
+	}
+	var hlen int
+	switch magic[6] {
+	case 1:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, err
+		}
+		hlen = int(binary.LittleEndian.Uint16(b))
+	case 2, 3:
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, err
+		}
+		hlen = int(binary.LittleEndian.Uint32(b))
+	default:
+		return nil, fmt.Errorf("unsupported npy version: %d", magic[6])
+	}
+	hb := make([]byte, hlen)
+	if _, err := io.ReadFull(br, hb); err != nil {
+		return nil, err
+	}
+	header := string(hb)
+
+	descr := npyDescrRE.FindStringSubmatch(header)
+	fortran := npyFortranRE.FindStringSubmatch(header)
+	shapeStr := npyShapeRE.FindStringSubmatch(header)
+	if descr == nil || fortran == nil || shapeStr == nil {
+		return nil, fmt.Errorf("bad npy header: %q", header)
+	}
+	a := &ndarray{}
+	for _, s := range strings.Split(shapeStr[1], ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad npy shape: %q", shapeStr[1])
+		}
+		a.shape = append(a.shape, n)
+	}
+	if fortran[1] == "True" && len(a.shape) > 1 {
+		return nil, fmt.Errorf("fortran order is not supported")
+	}
+
+	dt, err := parseDtype(descr[1])
+	if err != nil {
+		return nil, err
+	}
+	if dt.kind == 'O' {
+		v, err := unpickle(br)
+		if err != nil {
+			return nil, err
+		}
+		pa, ok := v.(*ndarray)
+		if !ok {
+			return nil, fmt.Errorf("pickled value is %T, want ndarray", v)
+		}
+		return pa, nil
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < a.size()*dt.size {
+		return nil, fmt.Errorf("npy data has %d bytes, want %d",
+			len(data), a.size()*dt.size)
+	}
+	if a.data, err = dt.decodeAll(data[:a.size()*dt.size]); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reads all arrays in an npz file, mapped by name.
+func readNPZ(file string) (map[string]*ndarray, error) {
+	z, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	result := map[string]*ndarray{}
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		a, err := readNPY(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		result[strings.TrimSuffix(f.Name, ".npy")] = a
+	}
+	return result, nil
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+)
+
+// A minimal unpickler, enough for reading numpy object arrays.
+//
+// Supports protocols 2 to 5 and the data types that numpy arrays are made of.
+// Python values are represented as follows:
+//   - None: nil
+//   - bool, int, float, str, bytes: bool, int, float64, string, []byte
+//   - list: *pyList
+//   - tuple: pyTuple
+//   - dict: *pyDict
+//   - numpy.ndarray: *ndarray
+//   - numpy scalars: their Go counterparts
+//
+// Opcode reference: https://github.com/python/cpython/blob/main/Lib/pickletools.py
+
+// A Python list.
+type pyList struct {
+	items []any
+}
+
+// A Python tuple.
+type pyTuple []any
+
+// A Python dict, keeping insertion order.
+type pyDict struct {
+	keys   []any
+	values []any
+}
+
+// Sets a key in the dict. Keys should be strings or numbers.
+func (d *pyDict) set(k, v any) {
+	if b, ok := k.([]byte); ok { // Unhashable in Go.
+		k = string(b)
+	}
+	for i, kk := range d.keys {
+		if kk == k {
+			d.values[i] = v
+			return
+		}
+	}
+	d.keys = append(d.keys, k)
+	d.values = append(d.values, v)
+}
+
+// A reference to a Python class or function.
+type pyGlobal struct {
+	module, name string
+}
+
+// A mark on the unpickler's stack.
+type pyMark struct{}
+
+// Reads a single pickled value from r.
+func unpickle(r io.Reader) (any, error) {
+	u := &unpickler{r: bufio.NewReader(r), memo: map[int]any{}}
+	return u.run()
+}
+
+// Unpickler state.
+type unpickler struct {
+	r     *bufio.Reader
+	stack []any
+	memo  map[int]any
+}
+
+// Executes opcodes until STOP.
+func (u *unpickler) run() (any, error) {
+	for {
+		op, err := u.r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("pickle: %w", err)
+		}
+		switch op {
+		case 0x80: // PROTO
+			if _, err := u.r.ReadByte(); err != nil {
+				return nil, err
+			}
+		case 0x95: // FRAME
+			if _, err := u.readN(8); err != nil {
+				return nil, err
+			}
+		case '.': // STOP
+			return u.pop()
+		case '(': // MARK
+			u.push(pyMark{})
+		case '0': // POP
+			if _, err := u.pop(); err != nil {
+				return nil, err
+			}
+		case '1': // POP_MARK
+			if _, err := u.popMark(); err != nil {
+				return nil, err
+			}
+		case '2': // DUP
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+			u.push(v)
+		case 'N': // NONE
+			u.push(nil)
+		case 0x88: // NEWTRUE
+			u.push(true)
+		case 0x89: // NEWFALSE
+			u.push(false)
+		case 'J': // BININT
+			b, err := u.readN(4)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int(int32(binary.LittleEndian.Uint32(b))))
+		case 'K': // BININT1
+			b, err := u.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			u.push(int(b))
+		case 'M': // BININT2
+			b, err := u.readN(2)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int(binary.LittleEndian.Uint16(b)))
+		case 0x8a: // LONG1
+			n, err := u.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			b, err := u.readN(int(n))
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeLong(b)
+			if err != nil {
+				return nil, err
+			}
+			u.push(v)
+		case 'G': // BINFLOAT
+			b, err := u.readN(8)
+			if err != nil {
+				return nil, err
+			}
+			u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case 'X': // BINUNICODE
+			b, err := u.readSized(4)
+			if err != nil {
+				return nil, err
+			}
+			u.push(string(b))
+		case 0x8c: // SHORT_BINUNICODE
+			b, err := u.readSized(1)
+			if err != nil {
+				return nil, err
+			}
+			u.push(string(b))
+		case 0x8d: // BINUNICODE8
+			b, err := u.readSized(8)
+			if err != nil {
+				return nil, err
+			}
+			u.push(string(b))
+		case 'U': // SHORT_BINSTRING
+			b, err := u.readSized(1)
+			if err != nil {
+				return nil, err
+			}
+			u.push(string(b))
+		case 'T': // BINSTRING
+			b, err := u.readSized(4)
+			if err != nil {
+				return nil, err
+			}
+			u.push(string(b))
+		case 'B': // BINBYTES
+			b, err := u.readSized(4)
+			if err != nil {
+				return nil, err
+			}
+			u.push(b)
+		case 'C': // SHORT_BINBYTES
+			b, err := u.readSized(1)
+			if err != nil {
+				return nil, err
+			}
+			u.push(b)
+		case 0x8e: // BINBYTES8
+			b, err := u.readSized(8)
+			if err != nil {
+				return nil, err
+			}
+			u.push(b)
+		case 0x96: // BYTEARRAY8
+			b, err := u.readSized(8)
+			if err != nil {
+				return nil, err
+			}
+			u.push(b)
+		case ')': // EMPTY_TUPLE
+			u.push(pyTuple{})
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			n := int(op - 0x84)
+			if len(u.stack) < n {
+				return nil, fmt.Errorf("pickle: stack underflow")
+			}
+			t := make(pyTuple, n)
+			copy(t, u.stack[len(u.stack)-n:])
+			u.stack = u.stack[:len(u.stack)-n]
+			u.push(t)
+		case 't': // TUPLE
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			u.push(pyTuple(items))
+		case ']': // EMPTY_LIST
+			u.push(&pyList{})
+		case 'l': // LIST
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			u.push(&pyList{items})
+		case 'a': // APPEND
+			v, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.appendTo([]any{v}); err != nil {
+				return nil, err
+			}
+		case 'e': // APPENDS
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.appendTo(items); err != nil {
+				return nil, err
+			}
+		case '}': // EMPTY_DICT
+			u.push(&pyDict{})
+		case 'd': // DICT
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			d := &pyDict{}
+			if err := setItems(d, items); err != nil {
+				return nil, err
+			}
+			u.push(d)
+		case 's': // SETITEM
+			if len(u.stack) < 3 {
+				return nil, fmt.Errorf("pickle: stack underflow")
+			}
+			items := u.stack[len(u.stack)-2:]
+			u.stack = u.stack[:len(u.stack)-2]
+			if err := u.setItemsTop(items); err != nil {
+				return nil, err
+			}
+		case 'u': // SETITEMS
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.setItemsTop(items); err != nil {
+				return nil, err
+			}
+		case 'q': // BINPUT
+			i, err := u.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.put(int(i)); err != nil {
+				return nil, err
+			}
+		case 'r': // LONG_BINPUT
+			b, err := u.readN(4)
+			if err != nil {
+				return nil, err
+			}
+			if err := u.put(int(binary.LittleEndian.Uint32(b))); err != nil {
+				return nil, err
+			}
+		case 0x94: // MEMOIZE
+			if err := u.put(len(u.memo)); err != nil {
+				return nil, err
+			}
+		case 'h': // BINGET
+			i, err := u.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.get(int(i)); err != nil {
+				return nil, err
+			}
+		case 'j': // LONG_BINGET
+			b, err := u.readN(4)
+			if err != nil {
+				return nil, err
+			}
+			if err := u.get(int(binary.LittleEndian.Uint32(b))); err != nil {
+				return nil, err
+			}
+		case 'c': // GLOBAL
+			module, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			name, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			u.push(pyGlobal{module, name})
+		case 0x93: // STACK_GLOBAL
+			if len(u.stack) < 2 {
+				return nil, fmt.Errorf("pickle: stack underflow")
+			}
+			module, ok1 := u.stack[len(u.stack)-2].(string)
+			name, ok2 := u.stack[len(u.stack)-1].(string)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("pickle: bad STACK_GLOBAL arguments")
+			}
+			u.stack = u.stack[:len(u.stack)-2]
+			u.push(pyGlobal{module, name})
+		case 'R', 0x81: // REDUCE, NEWOBJ
+			args, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			f, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			v, err := callGlobal(f, args)
+			if err != nil {
+				return nil, err
+			}
+			u.push(v)
+		case 'b': // BUILD
+			state, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+			if err := build(v, state); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("pickle: unsupported opcode: 0x%02x", op)
+		}
+	}
+}
+
+// Pushes a value to the stack.
+func (u *unpickler) push(v any) {
+	u.stack = append(u.stack, v)
+}
+
+// Pops a value from the stack.
+func (u *unpickler) pop() (any, error) {
+	v, err := u.top()
+	if err != nil {
+		return nil, err
+	}
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+// Returns the value at the top of the stack.
+func (u *unpickler) top() (any, error) {
+	if len(u.stack) == 0 {
+		return nil, fmt.Errorf("pickle: stack underflow")
+	}
+	return u.stack[len(u.stack)-1], nil
+}
+
+// Pops all values up to the last mark, and the mark itself.
+func (u *unpickler) popMark() ([]any, error) {
+	for i := len(u.stack) - 1; i >= 0; i-- {
+		if _, ok := u.stack[i].(pyMark); ok {
+			items := append([]any(nil), u.stack[i+1:]...)
+			u.stack = u.stack[:i]
+			return items, nil
+		}
+	}
+	return nil, fmt.Errorf("pickle: mark not found")
+}
+
+// Appends items to the list at the top of the stack.
+func (u *unpickler) appendTo(items []any) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	l, ok := v.(*pyList)
+	if !ok {
+		return fmt.Errorf("pickle: cannot append to %T", v)
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+// Sets key-value pairs in the dict at the top of the stack.
+func (u *unpickler) setItemsTop(items []any) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	d, ok := v.(*pyDict)
+	if !ok {
+		return fmt.Errorf("pickle: cannot set items in %T", v)
+	}
+	return setItems(d, items)
+}
+
+// Sets alternating keys and values in d.
+func setItems(d *pyDict, items []any) error {
+	if len(items)%2 != 0 {
+		return fmt.Errorf("pickle: odd number of dict items: %d", len(items))
+	}
+	for i := 0; i < len(items); i += 2 {
+		d.set(items[i], items[i+1])
+	}
+	return nil
+}
+
+// Stores the top of the stack in the memo.
+func (u *unpickler) put(i int) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	u.memo[i] = v
+	return nil
+}
+
+// Pushes a memo value to the stack.
+func (u *unpickler) get(i int) error {
+	v, ok := u.memo[i]
+	if !ok {
+		return fmt.Errorf("pickle: memo key not found: %d", i)
+	}
+	u.push(v)
+	return nil
+}
+
+// Reads exactly n bytes.
+func (u *unpickler) readN(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(u.r, b); err != nil {
+		return nil, fmt.Errorf("pickle: %w", err)
+	}
+	return b, nil
+}
+
+// Reads a little-endian length of the given size, followed by that many
+// bytes.
+func (u *unpickler) readSized(size int) ([]byte, error) {
+	b, err := u.readN(size)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, make([]byte, 8-size)...)
+	n := binary.LittleEndian.Uint64(b)
+	if n > math.MaxInt32 {
+		return nil, fmt.Errorf("pickle: value too long: %d", n)
+	}
+	return u.readN(int(n))
+}
+
+// Reads a newline-terminated string.
+func (u *unpickler) readLine() (string, error) {
+	s, err := u.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("pickle: %w", err)
+	}
+	return s[:len(s)-1], nil
+}
+
+// Decodes a little-endian two's complement integer.
+func decodeLong(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	n := new(big.Int).SetBytes(be)
+	if b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("pickle: integer too large: %v", n)
+	}
+	return int(n.Int64()), nil
+}
+
+// Calls a supported Python class or function.
+func callGlobal(f, args any) (any, error) {
+	g, ok := f.(pyGlobal)
+	if !ok {
+		return nil, fmt.Errorf("pickle: cannot call %T", f)
+	}
+	t, ok := args.(pyTuple)
+	if !ok {
+		return nil, fmt.Errorf("pickle: bad arguments for %v.%v: %T",
+			g.module, g.name, args)
+	}
+	switch g {
+	case pyGlobal{"numpy.core.multiarray", "_reconstruct"},
+		pyGlobal{"numpy._core.multiarray", "_reconstruct"}:
+		return &ndarray{}, nil // Populated by BUILD.
+	case pyGlobal{"numpy", "dtype"}:
+		if len(t) == 0 {
+			return nil, fmt.Errorf("pickle: dtype without arguments")
+		}
+		s, ok := t[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("pickle: bad dtype argument: %v", t[0])
+		}
+		return parseDtype("|" + s)
+	case pyGlobal{"numpy.core.multiarray", "scalar"},
+		pyGlobal{"numpy._core.multiarray", "scalar"}:
+		if len(t) != 2 {
+			return nil, fmt.Errorf("pickle: scalar with %d arguments", len(t))
+		}
+		dt, ok1 := t[0].(*dtype)
+		b, ok2 := t[1].([]byte)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("pickle: bad scalar arguments: %T, %T",
+				t[0], t[1])
+		}
+		return dt.decode(b)
+	case pyGlobal{"_codecs", "encode"}:
+		// Protocol 2 pickles bytes as latin-1 strings.
+		if len(t) != 2 {
+			return nil, fmt.Errorf("pickle: encode with %d arguments", len(t))
+		}
+		s, ok := t[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("pickle: bad encode argument: %T", t[0])
+		}
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		return b, nil
+	case pyGlobal{"collections", "OrderedDict"},
+		pyGlobal{"builtins", "dict"}, pyGlobal{"__builtin__", "dict"}:
+		return &pyDict{}, nil
+	case pyGlobal{"builtins", "list"}, pyGlobal{"__builtin__", "list"}:
+		return &pyList{}, nil
+	}
+	return nil, fmt.Errorf("pickle: unsupported global: %s.%s",
+		g.module, g.name)
+}
+
+// Applies a BUILD state to a value.
+func build(v, state any) error {
+	t, _ := state.(pyTuple)
+	switch v := v.(type) {
+	case *dtype:
+		// (version, byte order, subarray, names, fields, elsize, ...)
+		if len(t) < 2 {
+			return fmt.Errorf("pickle: bad dtype state: %v", state)
+		}
+		if order, ok := t[1].(string); ok && len(order) == 1 {
+			v.order = order[0]
+			if v.order == '=' {
+				v.order = '<'
+			}
+		}
+		if len(t) >= 6 {
+			if n, ok := t[5].(int); ok && n > 0 && v.kind != 'O' {
+				v.size = n
+			}
+		}
+		return nil
+	case *ndarray:
+		// (version, shape, dtype, is fortran, data)
+		if len(t) != 5 {
+			return fmt.Errorf("pickle: bad ndarray state: %v", state)
+		}
+		shape, ok := t[1].(pyTuple)
+		if !ok {
+			return fmt.Errorf("pickle: bad ndarray shape: %v", t[1])
+		}
+		dt, ok := t[2].(*dtype)
+		if !ok {
+			return fmt.Errorf("pickle: bad ndarray dtype: %v", t[2])
+		}
+		if fortran, _ := t[3].(bool); fortran && len(shape) > 1 {
+			return fmt.Errorf("pickle: fortran order is not supported")
+		}
+		for _, s := range shape {
+			n, ok := s.(int)
+			if !ok {
+				return fmt.Errorf("pickle: bad ndarray shape: %v", t[1])
+			}
+			v.shape = append(v.shape, n)
+		}
+		switch data := t[4].(type) {
+		case []byte:
+			items, err := dt.decodeAll(data)
+			if err != nil {
+				return err
+			}
+			v.data = items
+		case *pyList:
+			v.data = data.items
+		default:
+			return fmt.Errorf("pickle: bad ndarray data: %T", t[4])
+		}
+		if len(v.data) != v.size() {
+			return fmt.Errorf("pickle: ndarray has %d elements, want %d",
+				len(v.data), v.size())
+		}
+		return nil
+	case *pyDict:
+		d, ok := state.(*pyDict)
+		if !ok {
+			return fmt.Errorf("pickle: bad dict state: %T", state)
+		}
+		for i := range d.keys {
+			v.set(d.keys[i], d.values[i])
+		}
+		return nil
+	}
+	return fmt.Errorf("pickle: cannot build %T", v)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/biostuff/formats/sam"
+	"github.com/fluhus/biostuff/sequtil"
+	"github.com/fluhus/izzy/model"
+)
+
+const (
+	// Mapping quality of simulated alignments.
+	samMapq = 60
+)
+
+// A reference sequence in SAM output.
+type samRef struct {
+	name string
+	n    int // Length
+}
+
+// Returns the SAM references of the given sequences, one per contig.
+func samRefs(lens []lenGroup) []samRef {
+	var result []samRef
+	for i, l := range lens {
+		if i > 0 && l.ref == lens[i-1].ref { // Same contig.
+			continue
+		}
+		result = append(result, samRef{l.name, l.contigLen})
+	}
+	return result
+}
+
+// Returns the SAM header for the given reference sequences.
+func samHeader(lens []lenGroup) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "@HD\tVN:1.6\tSO:unsorted\n")
+	for _, r := range samRefs(lens) {
+		fmt.Fprintf(buf, "@SQ\tSN:%s\tLN:%d\n", r.name, r.n)
+	}
+	fmt.Fprintf(buf, "@PG\tID:izzy\tPN:izzy\tVN:%s\tCL:%s\n",
+		version, strings.Join(os.Args, " "))
+	return buf.Bytes()
+}
+
+// Returns the name of a contig as it appears in SAM, which is the first
+// word of its fasta name.
+func samRefName(name []byte) string {
+	if i := bytes.IndexAny(name, " \t"); i != -1 {
+		name = name[:i]
+	}
+	return string(name)
+}
+
+// Returns SAM entries for a simulated pair, aligned to the chunk's contig.
+// qname should be the same for both mates. The first two entries are the
+// primary alignments of R1 and R2, followed by any supplementary ones.
+func samPair(p *model.Pair, c *readChunk, qname string) []*sam.SAM {
+	rname := samRefName(c.name)
+	fwd := samRead(p.Fwd, c.seq, rname)
+	bwd := samRead(p.Bwd, c.seq, rname)
+	for _, s := range append(slices.Clone(fwd), bwd...) {
+		s.Qname = qname
+		s.Rnext = "="
+		s.Flag.SetMultiple(true)
+		s.Flag.SetEach(true)
+	}
+	for _, s := range fwd {
+		s.Flag.SetFirst(true)
+		s.Flag.SetReverseComplement2(p.Bwd.Reverse)
+		s.Pnext = bwd[0].Pos
+	}
+	for _, s := range bwd {
+		s.Flag.SetLast(true)
+		s.Flag.SetReverseComplement2(p.Fwd.Reverse)
+		s.Pnext = fwd[0].Pos
+	}
+
+	// TLEN is positive for the leftmost mate, which is the plus strand one.
+	left, right := p.Fwd, p.Bwd
+	if left.Reverse {
+		left, right = right, left
+	}
+	tlen := right.End - left.Start
+	fwd[0].Tlen, bwd[0].Tlen = tlen, -tlen
+	if p.Fwd.Reverse {
+		fwd[0].Tlen, bwd[0].Tlen = -tlen, tlen
+	}
+	result := []*sam.SAM{fwd[0], bwd[0]}
+	result = append(result, fwd[1:]...)
+	return append(result, bwd[1:]...)
+}
+
+// Returns SAM entries for a simulated single-end read, aligned to the
+// chunk's contig. The first entry is the primary alignment.
+func samSingle(r *model.Read, c *readChunk, qname string) []*sam.SAM {
+	result := samRead(r, c.seq, samRefName(c.name))
+	for _, s := range result {
+		s.Qname = qname
+		s.Rnext = "*"
+	}
+	return result
+}
+
+// Returns SAM entries for a single read aligned to ref, named rname.
+// Minus strand reads are reverse-complemented to the reference's
+// orientation. Reads of circular contigs that span the origin are split
+// there into a primary alignment and a supplementary one, each
+// soft-clipping the bases of the other and pointing to it with an SA tag.
+// The first entry is the primary alignment.
+func samRead(r *model.Read, ref []byte, rname string) []*sam.SAM {
+	seq := bytes.ToUpper(r.Sequence)
+	qual := slices.Clone(r.Quals)
+	ops := slices.Clone(r.Ops)
+	if r.Reverse {
+		seq = sequtil.ReverseComplement(nil, seq)
+		slices.Reverse(qual)
+		slices.Reverse(ops)
+	}
+	parts := samParts(ops, r.Start%len(ref), len(ref))
+
+	// The part with the most aligned bases is the primary one.
+	primary := 0
+	for i, p := range parts {
+		if bytes.Count(p.ops, []byte("M")) >
+			bytes.Count(parts[primary].ops, []byte("M")) {
+			primary = i
+		}
+	}
+	parts[0], parts[primary] = parts[primary], parts[0]
+
+	var result []*sam.SAM
+	for i, p := range parts {
+		refLen := len(p.ops) - bytes.Count(p.ops, []byte("I"))
+		md, nm := samMD(seq[p.qstart:p.qend], ref[p.pos:p.pos+refLen], p.ops)
+		cigar := samCigar(p.ops)
+		if p.qstart > 0 {
+			cigar = fmt.Sprintf("%dS%s", p.qstart, cigar)
+		}
+		if p.qend < len(seq) {
+			cigar = fmt.Sprintf("%s%dS", cigar, len(seq)-p.qend)
+		}
+		s := &sam.SAM{
+			Rname: rname,
+			Pos:   p.pos + 1,
+			Mapq:  samMapq,
+			Cigar: cigar,
+			Seq:   string(seq),
+			Qual:  string(qual),
+			Tags:  map[string]any{"MD": md, "NM": nm},
+		}
+		s.Flag.SetReverseComplement(r.Reverse)
+		s.Flag.SetSupplementary(i > 0)
+		result = append(result, s)
+	}
+	if len(result) > 1 {
+		strand := "+"
+		if r.Reverse {
+			strand = "-"
+		}
+		for _, s := range result {
+			var sa []byte
+			for _, other := range result {
+				if other != s {
+					sa = fmt.Appendf(sa, "%s,%d,%s,%s,%d,%d;", rname,
+						other.Pos, strand, other.Cigar, other.Mapq,
+						other.Tags["NM"])
+				}
+			}
+			s.Tags["SA"] = string(sa)
+		}
+	}
+	return result
+}
+
+// A part of an alignment that does not span the origin of its reference.
+type samPart struct {
+	ops    []byte // Alignment operations
+	pos    int    // 0-based start on the reference
+	qstart int    // Start of the aligned bases in the read
+	qend   int    // End of the aligned bases in the read, exclusive
+}
+
+// Splits alignment operations that start at pos on a circular reference
+// of length n into parts at its origin. Insertions and deletions next to
+// the origin are dropped, so that parts start and end with matches there.
+func samParts(ops []byte, pos, n int) []samPart {
+	var result []samPart
+	cur := samPart{pos: pos}
+	rpos, q := pos, 0 // Positions on the reference and the read.
+	for _, op := range ops {
+		if op != 'I' && rpos == n { // Crossing the origin.
+			cur.qend = q
+			result = appendSamPart(result, cur, len(result) > 0, true)
+			cur = samPart{pos: 0, qstart: q}
+			rpos = 0
+		}
+		cur.ops = append(cur.ops, op)
+		if op != 'I' {
+			rpos++
+		}
+		if op != 'D' {
+			q++
+		}
+	}
+	cur.qend = q
+	return appendSamPart(result, cur, len(result) > 0, false)
+}
+
+// Appends a part of an alignment after trimming its insertions and
+// deletions at the requested ends. Parts left with no matches are
+// dropped, unless nothing else is left.
+func appendSamPart(parts []samPart, p samPart, trimStart, trimEnd bool,
+) []samPart {
+	orig := p
+	for trimStart && len(p.ops) > 0 && p.ops[0] != 'M' {
+		if p.ops[0] == 'D' {
+			p.pos++
+		} else {
+			p.qstart++
+		}
+		p.ops = p.ops[1:]
+	}
+	for trimEnd && len(p.ops) > 0 && p.ops[len(p.ops)-1] != 'M' {
+		if p.ops[len(p.ops)-1] == 'I' {
+			p.qend--
+		}
+		p.ops = p.ops[:len(p.ops)-1]
+	}
+	if len(p.ops) == 0 {
+		if len(parts) == 0 && !trimEnd { // The last chance for a part.
+			return append(parts, orig)
+		}
+		return parts
+	}
+	return append(parts, p)
+}
+
+// Returns the CIGAR string of the given alignment operations.
+func samCigar(ops []byte) string {
+	var buf []byte
+	for len(ops) > 0 {
+		n := 1
+		for n < len(ops) && ops[n] == ops[0] {
+			n++
+		}
+		buf = strconv.AppendInt(buf, int64(n), 10)
+		buf = append(buf, ops[0])
+		ops = ops[n:]
+	}
+	return string(buf)
+}
+
+// Returns the MD tag and edit distance of a read against its reference,
+// both in the reference's orientation.
+func samMD(seq, ref, ops []byte) (string, int) {
+	var buf []byte
+	nm, matches := 0, 0
+	var prev byte
+	for _, op := range ops {
+		switch op {
+		case 'M':
+			if sequtil.Ntoi(seq[0]) == sequtil.Ntoi(ref[0]) &&
+				sequtil.Ntoi(seq[0]) != -1 {
+				matches++
+			} else {
+				buf = strconv.AppendInt(buf, int64(matches), 10)
+				buf = append(buf, upper(ref[0]))
+				matches = 0
+				nm++
+			}
+			seq, ref = seq[1:], ref[1:]
+		case 'I':
+			seq = seq[1:]
+			nm++
+		case 'D':
+			if prev != 'D' {
+				buf = strconv.AppendInt(buf, int64(matches), 10)
+				buf = append(buf, '^')
+				matches = 0
+			}
+			buf = append(buf, upper(ref[0]))
+			ref = ref[1:]
+			nm++
+		}
+		prev = op
+	}
+	buf = strconv.AppendInt(buf, int64(matches), 10)
+	return string(buf), nm
+}
+
+// Returns the upper case of a letter.
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
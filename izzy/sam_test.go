@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/fastq"
+	"github.com/fluhus/biostuff/formats/sam"
+	"github.com/fluhus/izzy/model"
+)
+
+func TestSamMD(t *testing.T) {
+	tests := []struct {
+		seq, ref, ops string
+		wantMD        string
+		wantNM        int
+	}{
+		{"ACGT", "ACGT", "MMMM", "4", 0},
+		{"ACGT", "AGGT", "MMMM", "1G2", 1},
+		{"ACGT", "ACGA", "MMMM", "3A0", 1},
+		{"ACGT", "acgt", "MMMM", "4", 0},
+		{"ACGT", "ACTTGT", "MMDDMM", "2^TT2", 2},
+		{"ACGT", "ACT", "MMIM", "3", 1},
+		{"ACGT", "ACTAT", "MMDMM", "2^T0A1", 2},
+		{"ACAGT", "ACTCGT", "MMDIDMM", "2^T0^C2", 3},
+	}
+	for _, test := range tests {
+		md, nm := samMD([]byte(test.seq), []byte(test.ref), []byte(test.ops))
+		if md != test.wantMD || nm != test.wantNM {
+			t.Errorf("samMD(%q,%q,%q)=%q,%d, want %q,%d",
+				test.seq, test.ref, test.ops, md, nm, test.wantMD, test.wantNM)
+		}
+	}
+}
+
+func TestSamCigar(t *testing.T) {
+	tests := []struct {
+		ops, want string
+	}{
+		{"", ""},
+		{"MMMM", "4M"},
+		{"MMIMMDDM", "2M1I2M2D1M"},
+	}
+	for _, test := range tests {
+		if got := samCigar([]byte(test.ops)); got != test.want {
+			t.Errorf("samCigar(%q)=%q, want %q", test.ops, got, test.want)
+		}
+	}
+}
+
+func TestSamPair(t *testing.T) {
+	ref := []byte("AACCGGTTAACCGGTT")
+	plus := &model.Read{Fastq: &fastq.Fastq{
+		Sequence: []byte("ACCG"), Quals: []byte("ABCD")},
+		Start: 1, End: 5, Ops: []byte("MMMM")}
+	minus := &model.Read{Fastq: &fastq.Fastq{
+		Sequence: []byte("CCGG"), Quals: []byte("EFGH")},
+		Start: 10, End: 14, Ops: []byte("MMMM"), Reverse: true}
+	c := &readChunk{seq: ref, name: []byte("chr1 desc")}
+
+	tests := []struct {
+		p         *model.Pair
+		wantFlags [2]sam.Flag
+		wantTlens [2]int
+		wantPos   [2]int
+		wantSeqs  [2]string
+	}{
+		{&model.Pair{Fwd: plus, Bwd: minus}, [2]sam.Flag{99, 147},
+			[2]int{13, -13}, [2]int{2, 11}, [2]string{"ACCG", "CCGG"}},
+		{&model.Pair{Fwd: minus, Bwd: plus}, [2]sam.Flag{83, 163},
+			[2]int{-13, 13}, [2]int{11, 2}, [2]string{"CCGG", "ACCG"}},
+	}
+	for _, test := range tests {
+		got := samPair(test.p, c, "r")
+		for i, s := range got {
+			if s.Flag != test.wantFlags[i] || s.Tlen != test.wantTlens[i] ||
+				s.Pos != test.wantPos[i] || s.Seq != test.wantSeqs[i] {
+				t.Errorf("samPair(...)[%d]: flag=%d tlen=%d pos=%d seq=%s, "+
+					"want %d %d %d %s", i, s.Flag, s.Tlen, s.Pos, s.Seq,
+					test.wantFlags[i], test.wantTlens[i], test.wantPos[i],
+					test.wantSeqs[i])
+			}
+			if s.Rname != "chr1" || s.Pnext != got[1-i].Pos {
+				t.Errorf("samPair(...)[%d]: rname=%s pnext=%d, want chr1 %d",
+					i, s.Rname, s.Pnext, got[1-i].Pos)
+			}
+		}
+	}
+}
+
+func TestSamParts(t *testing.T) {
+	tests := []struct {
+		ops  string
+		pos  int
+		want []samPart
+	}{
+		{"MMMM", 2, []samPart{{[]byte("MMMM"), 2, 0, 4}}},
+		{"MMMM", 8, []samPart{{[]byte("MM"), 8, 0, 2}, {[]byte("MM"), 0, 2, 4}}},
+		{"MMDMM", 8, []samPart{{[]byte("MM"), 8, 0, 2}, {[]byte("MM"), 1, 2, 4}}},
+		{"MMIMM", 8, []samPart{{[]byte("MM"), 8, 0, 2}, {[]byte("MM"), 0, 3, 5}}},
+		{"MDMM", 9, []samPart{{[]byte("M"), 9, 0, 1}, {[]byte("MM"), 1, 1, 3}}},
+		{"DMMM", 9, []samPart{{[]byte("MMM"), 0, 0, 3}}},
+		{"IMMDM", 4, []samPart{{[]byte("IMMDM"), 4, 0, 4}}},
+	}
+	for _, test := range tests {
+		got := samParts([]byte(test.ops), test.pos, 10)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("samParts(%q,%d,10)=%v, want %v",
+				test.ops, test.pos, got, test.want)
+		}
+	}
+}
+
+func TestSamRead_origin(t *testing.T) {
+	ref := []byte("ACGTACGTAC")
+	tests := []struct {
+		r                         *model.Read
+		wantPos, wantFlag         [2]int
+		wantCigar, wantMD, wantSA [2]string
+	}{
+		{&model.Read{Fastq: &fastq.Fastq{
+			Sequence: []byte("ACAC"), Quals: []byte("ABCD")},
+			Start: 8, End: 12, Ops: []byte("MMMM")},
+			[2]int{9, 1}, [2]int{0, 2048}, [2]string{"2M2S", "2S2M"}, [2]string{"2", "2"},
+			[2]string{"chr1,1,+,2S2M,60,0;", "chr1,9,+,2M2S,60,0;"}},
+		{&model.Read{Fastq: &fastq.Fastq{
+			Sequence: []byte("CGTG"), Quals: []byte("ABCD")},
+			Start: 9, End: 13, Ops: []byte("MMMM"), Reverse: true},
+			[2]int{1, 10}, [2]int{16, 2064}, [2]string{"1S3M", "1M3S"}, [2]string{"3", "1"},
+			[2]string{"chr1,10,-,1M3S,60,0;", "chr1,1,-,1S3M,60,0;"}},
+	}
+	for _, test := range tests {
+		got := samRead(test.r, ref, "chr1")
+		if len(got) != 2 {
+			t.Fatalf("samRead(...) returned %d entries, want 2", len(got))
+		}
+		for i, s := range got {
+			if s.Pos != test.wantPos[i] || int(s.Flag) != test.wantFlag[i] ||
+				s.Cigar != test.wantCigar[i] || s.Tags["MD"] != test.wantMD[i] ||
+				s.Tags["SA"] != test.wantSA[i] {
+				t.Errorf("samRead(...)[%d]: pos=%d flag=%d cigar=%s MD=%s SA=%s, "+
+					"want %d %d %s %s %s", i, s.Pos, s.Flag, s.Cigar, s.Tags["MD"],
+					s.Tags["SA"], test.wantPos[i], test.wantFlag[i],
+					test.wantCigar[i], test.wantMD[i], test.wantSA[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fluhus/gostuff/ppln"
+)
+
+// Shuffling of the output in bounded memory. Each fragment (read pair or
+// single read) is assigned to a random bucket file, and then each bucket is
+// shuffled in memory and written to the output. Together, this gives a
+// uniformly random order.
+
+const (
+	// Target size of a bucket in bytes. Each thread holds about one bucket
+	// in memory while shuffling.
+	shuffleBucketBytes = 256 << 20
+
+	// Estimated size of a read in a bucket beyond its bases and qualities,
+	// for its name, SAM fields and truth row.
+	shuffleReadOverhead = 200
+
+	// Size of the in-memory buffer of each bucket, after which it is
+	// appended to its file. Files are not kept open, so the number of
+	// buckets is not limited by open file limits.
+	shuffleWriteBuffer = 32 << 10
+)
+
+// Returns the number of shuffle buckets for the requested number of
+// fragments with the given read length, so that buckets are about
+// shuffleBucketBytes each.
+func numShuffleBuckets(readLen int) int {
+	size := *nReads * readsPerFragment() * (2*readLen + shuffleReadOverhead)
+	return size/shuffleBucketBytes + 1
+}
+
+// Splits the buffers of a chunk into fragments and frames each one with
+// a random bucket out of nbuckets and the lengths of its parts. ends are
+// the end offsets of each fragment in the buffers.
+func frameFragments(buf1, buf2, tbuf *bytes.Buffer, ends [][3]int,
+	nbuckets int, rng *rand.Rand) []byte {
+	bufs := [3][]byte{buf1.Bytes(), buf2.Bytes(), tbuf.Bytes()}
+	if buf2 == buf1 {
+		bufs[1] = nil
+	}
+	var result []byte
+	var prev [3]int
+	for _, end := range ends {
+		result = binary.AppendUvarint(result, uint64(rng.IntN(nbuckets)))
+		for i, b := range bufs {
+			var part []byte
+			if b != nil {
+				part = b[prev[i]:end[i]]
+			}
+			result = binary.AppendUvarint(result, uint64(len(part)))
+			result = append(result, part...)
+		}
+		prev = end
+	}
+	return result
+}
+
+// Parses the first fragment in b, without its bucket. Returns its parts
+// (R1, R2 and truth) and its size in b.
+func nextFragment(b []byte) ([3][]byte, int, error) {
+	var parts [3][]byte
+	size := 0
+	for i := range parts {
+		n, nn := binary.Uvarint(b[size:])
+		if nn <= 0 || uint64(len(b)-size-nn) < n {
+			return parts, 0, fmt.Errorf("bad shuffle frame")
+		}
+		size += nn
+		parts[i] = b[size : size+int(n)]
+		size += int(n)
+	}
+	return parts, size, nil
+}
+
+// Temporary bucket files for shuffling.
+type shuffler struct {
+	dir   string
+	files []string
+	bufs  [][]byte // Data not yet appended to each file
+}
+
+// Creates n bucket files in a temporary directory next to the given
+// output prefix.
+func newShuffler(prefix string, n int) (*shuffler, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(prefix),
+		filepath.Base(prefix)+"_shuffle_")
+	if err != nil {
+		return nil, err
+	}
+	s := &shuffler{dir: dir, bufs: make([][]byte, n)}
+	for i := range n {
+		file := filepath.Join(dir, fmt.Sprint(i))
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			s.remove()
+			return nil, err
+		}
+		s.files = append(s.files, file)
+	}
+	return s, nil
+}
+
+// Adds framed fragments to their buckets.
+func (s *shuffler) add(frames []byte) error {
+	for len(frames) > 0 {
+		b, n := binary.Uvarint(frames)
+		if n <= 0 || b >= uint64(len(s.bufs)) {
+			return fmt.Errorf("bad shuffle bucket")
+		}
+		frames = frames[n:]
+		_, size, err := nextFragment(frames)
+		if err != nil {
+			return err
+		}
+		s.bufs[b] = append(s.bufs[b], frames[:size]...)
+		if len(s.bufs[b]) >= shuffleWriteBuffer {
+			if err := s.flush(int(b)); err != nil {
+				return err
+			}
+		}
+		frames = frames[size:]
+	}
+	return nil
+}
+
+// Appends the buffered data of the i'th bucket to its file.
+func (s *shuffler) flush(i int) error {
+	f, err := os.OpenFile(s.files[i], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(s.bufs[i]); err != nil {
+		f.Close()
+		return err
+	}
+	s.bufs[i] = s.bufs[i][:0]
+	return f.Close()
+}
+
+// Writes the remaining buffered data to the bucket files.
+func (s *shuffler) close() error {
+	for i := range s.bufs {
+		if err := s.flush(i); err != nil {
+			return err
+		}
+		s.bufs[i] = nil
+	}
+	return nil
+}
+
+// Reads the i'th bucket, shuffles its fragments and compresses them for
+// output.
+func (s *shuffler) shuffleBucket(i int, seed uint64) (*chunkOutput, error) {
+	data, err := os.ReadFile(s.files[i])
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.files[i]); err != nil {
+		return nil, err
+	}
+	var frags [][3][]byte
+	for len(data) > 0 {
+		parts, size, err := nextFragment(data)
+		if err != nil {
+			return nil, err
+		}
+		frags = append(frags, parts)
+		data = data[size:]
+	}
+	rng := rand.New(rand.NewPCG(seed, uint64(i)))
+	rng.Shuffle(len(frags), func(i, j int) {
+		frags[i], frags[j] = frags[j], frags[i]
+	})
+	buf1, buf2, tbuf := newChunkBuffers()
+	for _, f := range frags {
+		buf1.Write(f[0])
+		buf2.Write(f[1])
+		tbuf.Write(f[2])
+	}
+	return compressChunk(buf1, buf2, tbuf, readsPerFragment()*len(frags))
+}
+
+// Removes the bucket files.
+func (s *shuffler) remove() error {
+	return os.RemoveAll(s.dir)
+}
+
+// Removes the bucket files and exits if the program is interrupted, until
+// the returned function is called.
+func (s *shuffler) removeOnInterrupt() (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-c:
+			s.remove()
+			die(fmt.Errorf("interrupted"))
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// Writes the shuffled contents of the buckets to the output files, using
+// the given seed for shuffling.
+func (o *outputs) writeShuffled(seed uint64) error {
+	s := o.shuf
+	if err := s.close(); err != nil {
+		return err
+	}
+	buckets := func(yield func(int, error) bool) {
+		for i := range s.files {
+			if !yield(i, nil) {
+				return
+			}
+		}
+	}
+	return ppln.Serial(*threads, buckets,
+		func(i int, _, _ int) (*chunkOutput, error) {
+			return s.shuffleBucket(i, seed)
+		}, o.write)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFrameFragments(t *testing.T) {
+	buf1 := bytes.NewBufferString("r1a\nr1bb\n")
+	buf2 := bytes.NewBufferString("r2a\nr2bb\n")
+	tbuf := bytes.NewBufferString("ta\ntbb\n")
+	ends := [][3]int{{4, 4, 3}, {9, 9, 7}}
+	want := [][3]string{{"r1a\n", "r2a\n", "ta\n"}, {"r1bb\n", "r2bb\n", "tbb\n"}}
+	if got := parseFrames(t, frameFragments(buf1, buf2, tbuf, ends, 1,
+		rand.New(rand.NewPCG(0, 0)))); !reflect.DeepEqual(got, want) {
+		t.Errorf("frameFragments(...)=%q, want %q", got, want)
+	}
+
+	// Single output, the second part is empty.
+	buf1 = bytes.NewBufferString("r1a\nr2a\nr1bb\nr2bb\n")
+	ends = [][3]int{{8, 8, 0}, {18, 18, 0}}
+	want = [][3]string{{"r1a\nr2a\n", "", ""}, {"r1bb\nr2bb\n", "", ""}}
+	if got := parseFrames(t, frameFragments(buf1, buf1, &bytes.Buffer{},
+		ends, 1, rand.New(rand.NewPCG(0, 0)))); !reflect.DeepEqual(got, want) {
+		t.Errorf("frameFragments(...)=%q, want %q", got, want)
+	}
+}
+
+// Returns the parts of each framed fragment, dropping the buckets.
+func parseFrames(t *testing.T, frames []byte) [][3]string {
+	var result [][3]string
+	for len(frames) > 0 {
+		_, n := binary.Uvarint(frames)
+		frames = frames[n:]
+		parts, size, err := nextFragment(frames)
+		if err != nil {
+			t.Fatalf("nextFragment(...) failed: %v", err)
+		}
+		result = append(result, [3]string{
+			string(parts[0]), string(parts[1]), string(parts[2])})
+		frames = frames[size:]
+	}
+	return result
+}
+
+func TestShuffler(t *testing.T) {
+	const nbuckets = 2000 // More than typical open file limits.
+	s, err := newShuffler(filepath.Join(t.TempDir(), "out"), nbuckets)
+	if err != nil {
+		t.Fatalf("newShuffler(...) failed: %v", err)
+	}
+	defer s.remove()
+
+	buf1, buf2, tbuf := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	var ends [][3]int
+	want := map[string]int{}
+	for i := range 10000 {
+		fmt.Fprintf(buf1, "r1_%d\n", i)
+		fmt.Fprintf(buf2, "r2_%d\n", i)
+		fmt.Fprintf(tbuf, "t_%d\n", i)
+		ends = append(ends, [3]int{buf1.Len(), buf2.Len(), tbuf.Len()})
+		want[fmt.Sprintf("r1_%d\nr2_%d\nt_%d\n", i, i, i)]++
+	}
+	if err := s.add(frameFragments(buf1, buf2, tbuf, ends, nbuckets,
+		rand.New(rand.NewPCG(0, 0)))); err != nil {
+		t.Fatalf("add(...) failed: %v", err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatalf("close() failed: %v", err)
+	}
+
+	got := map[string]int{}
+	for i := range nbuckets {
+		data, err := os.ReadFile(s.files[i])
+		if err != nil {
+			t.Fatalf("ReadFile(%q) failed: %v", s.files[i], err)
+		}
+		for len(data) > 0 {
+			parts, size, err := nextFragment(data)
+			if err != nil {
+				t.Fatalf("nextFragment(...) failed: %v", err)
+			}
+			got[string(parts[0])+string(parts[1])+string(parts[2])]++
+			data = data[size:]
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shuffler has %d fragments, want %d", len(got), len(want))
+	}
+
+	if err := s.remove(); err != nil {
+		t.Fatalf("remove() failed: %v", err)
+	}
+	if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+		t.Errorf("Stat(%q)=%v, want not exist", s.dir, err)
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"iter"
+	"math"
+	"math/rand/v2"
+
+	"github.com/fluhus/biostuff/formats/fasta"
+	"github.com/fluhus/gostuff/aio"
+	"github.com/fluhus/gostuff/ppln"
+	"github.com/fluhus/gostuff/ptimer"
+	"github.com/fluhus/izzy/model"
+)
+
+const (
+	// Maximal number of fragments (read pairs or single reads) in a chunk.
+	// Chunks are the unit of work for threads and each one gets its own
+	// random generator, so changing this value changes the output.
+	chunkSize = 10000
+)
+
+// A batch of read pairs to simulate from a single contig.
+type readChunk struct {
+	seq   []byte // Contig sequence
+	start int    // Start of the range to simulate from
+	end   int    // End of the range to simulate from, exclusive
+	circ  bool   // Whether reads can wrap around the end of the contig
+	name  []byte // Contig name
+	group string // Group name
+	ref   int    // Index of the contig among the input sequences
+	n     int    // Number of fragments to simulate
+	id    int    // Serial number of the first read in the chunk
+	seed  uint64 // Seed for this chunk's random generator
+}
+
+// Simulated reads of a single chunk, gzipped.
+type chunkOutput struct {
+	r1     []byte // Forward reads, or all reads if single output or SAM
+	r2     []byte // Reverse reads, nil if single output, single-end or SAM
+	truth  []byte // Truth table rows, nil if not requested
+	n      int    // Number of reads (not pairs)
+	group  string // Group of the reads
+	frames []byte // Uncompressed fragments for the shuffler, if shuffling
+}
+
+// Returns an iterator over read chunks from the input files.
+// counts has the number of fragments of each sequence in lens. If nil,
+// they are drawn from the global rng in input order, along with the chunk
+// seeds.
+func readChunks(m *model.Model, lens []lenGroup, counts []int,
+	groupLens map[string]int, groupRatios map[string]float64,
+) iter.Seq2[*readChunk, error] {
+	return func(yield func(*readChunk, error) bool) {
+		id := 0
+		for _, f := range inFiles {
+			for fa, err := range fasta.File(f) {
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				if _, ok := groupName(f, fa.Name, *re); !ok {
+					continue
+				}
+				pieces := contigPieces(fa.Sequence, *ambigMode)
+				if len(pieces) == 0 {
+					continue
+				}
+				fixAmbiguous(fa.Sequence, *ambigMode, rng)
+				circ := isWholeCircular(fa, pieces)
+				for _, p := range pieces {
+					gl := lens[0]
+					lens = lens[1:]
+					var nreads int
+					if counts != nil {
+						nreads = counts[0]
+						counts = counts[1:]
+					} else {
+						groupReads := groupRatios[gl.g] * float64(*nReads)
+						seqRatio := float64(gl.n) / float64(groupLens[gl.g])
+
+						// Convert fraction to whole number.
+						nreadsf := groupReads * seqRatio
+						nreads = int(math.Floor(nreadsf))
+						if rng.Float64() < nreadsf-math.Floor(nreadsf) {
+							nreads++
+						}
+					}
+					if gl.n < minSeqLen(m) { // Sequence is too short.
+						continue
+					}
+
+					for nreads > 0 {
+						n := min(nreads, chunkSize)
+						c := &readChunk{
+							seq:   fa.Sequence,
+							start: p.start,
+							end:   p.end,
+							circ:  circ,
+							name:  fa.Name,
+							group: gl.g,
+							ref:   gl.ref,
+							n:     n,
+							id:    id,
+							seed:  rng.Uint64(),
+						}
+						if !yield(c, nil) {
+							return
+						}
+						nreads -= n
+						id += readsPerFragment() * n
+					}
+				}
+			}
+		}
+	}
+}
+
+// Simulates all reads into output files with the given prefix.
+// Returns the number of simulated fragments of each group.
+func simulate(prefix string, m *model.Model, opts model.Options,
+	lens []lenGroup, counts []int, groupLens map[string]int,
+	groupRatios map[string]float64) (map[string]int, error) {
+	fout, err := createOutputs(prefix, lens, m.ReadLen)
+	if err != nil {
+		return nil, err
+	}
+	if fout.shuf != nil {
+		defer fout.shuf.remove()
+		defer fout.shuf.removeOnInterrupt()()
+	}
+
+	pt := ptimer.NewMessage("{} reads generated")
+	fragments := map[string]int{} // Simulated fragments of each group.
+	err = ppln.Serial(*threads,
+		readChunks(m, lens, counts, groupLens, groupRatios),
+		func(c *readChunk, i, g int) (*chunkOutput, error) {
+			return simulateChunk(c, m, opts)
+		},
+		func(out *chunkOutput) error {
+			if err := fout.write(out); err != nil {
+				return err
+			}
+			fragments[out.group] += out.n / readsPerFragment()
+			for range out.n {
+				pt.Inc()
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	pt.Done()
+	if *shuffle {
+		fmt.Println("Shuffling reads")
+		if err := fout.writeShuffled(rng.Uint64()); err != nil {
+			return nil, err
+		}
+	}
+	if err := fout.close(); err != nil {
+		return nil, err
+	}
+	return fragments, nil
+}
+
+// Simulates the reads of a single chunk and compresses them.
+// When shuffling, the reads are framed for the shuffler instead.
+func simulateChunk(c *readChunk, m *model.Model, opts model.Options,
+) (*chunkOutput, error) {
+	rng := rand.New(rand.NewPCG(c.seed, 0))
+	buf1, buf2, tbuf := newChunkBuffers()
+	var ends [][3]int // Where each fragment ends in the buffers.
+	fragDone := func() {}
+	if *shuffle {
+		fragDone = func() {
+			ends = append(ends, [3]int{buf1.Len(), buf2.Len(), tbuf.Len()})
+		}
+	}
+	var err error
+	if *singleEnd {
+		err = simulateSingles(c, m, opts, rng, buf1, tbuf, fragDone)
+	} else {
+		err = simulatePairs(c, m, opts, rng, buf1, buf2, tbuf, fragDone)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if *shuffle {
+		// A separate generator, so that the reads are the same as without
+		// shuffling.
+		srng := rand.New(rand.NewPCG(c.seed, 1))
+		return &chunkOutput{
+			n:     readsPerFragment() * c.n,
+			group: c.group,
+			frames: frameFragments(buf1, buf2, tbuf, ends,
+				numShuffleBuckets(m.ReadLen), srng),
+		}, nil
+	}
+	out, err := compressChunk(buf1, buf2, tbuf, readsPerFragment()*c.n)
+	if err != nil {
+		return nil, err
+	}
+	out.group = c.group
+	return out, nil
+}
+
+// Returns buffers for the reads and truth rows of a chunk. The second
+// buffer is the same as the first if there is a single output file.
+func newChunkBuffers() (buf1, buf2, tbuf *bytes.Buffer) {
+	buf1, buf2, tbuf = &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	if *singleOutput || *singleEnd || *outFormat != "fastq" {
+		buf2 = buf1
+	}
+	return
+}
+
+// Compresses the contents of a chunk's buffers for output. n is the number
+// of reads in them.
+func compressChunk(buf1, buf2, tbuf *bytes.Buffer, n int) (*chunkOutput,
+	error) {
+	out := &chunkOutput{n: n}
+	var err error
+	switch *outFormat {
+	case "fastq":
+		out.r1, err = gzipBytes(buf1.Bytes())
+	case "sam":
+		out.r1 = buf1.Bytes()
+	case "bam":
+		out.r1, err = bgzfBytes(buf1.Bytes())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buf2 != buf1 {
+		if out.r2, err = gzipBytes(buf2.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if *truthOutput {
+		if out.truth, err = gzipBytes(tbuf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Simulates the read pairs of a chunk into the given buffers.
+// fragDone is called after each pair.
+func simulatePairs(c *readChunk, m *model.Model, opts model.Options,
+	rng *rand.Rand, buf1, buf2, tbuf *bytes.Buffer, fragDone func()) error {
+	for i := range c.n {
+		var p *model.Pair
+		if c.circ {
+			p = m.SimulatePairCircular(c.seq, rng, opts)
+		} else {
+			p = m.SimulatePair(c.seq[c.start:c.end], rng, opts)
+			shiftRead(p.Fwd, c.start)
+			shiftRead(p.Bwd, c.start)
+		}
+		fwd, bwd := p.Fwd.Fastq, p.Bwd.Fastq
+		if len(fwd.Sequence) != m.ReadLen {
+			return fmt.Errorf("bad read length: %d, want %d",
+				len(fwd.Sequence), m.ReadLen)
+		}
+		if len(bwd.Sequence) != m.ReadLen {
+			return fmt.Errorf("bad read length: %d, want %d",
+				len(bwd.Sequence), m.ReadLen)
+		}
+		fwd.Name = readName(c.id+2*i+1, p.Fwd, c)
+		bwd.Name = readName(c.id+2*i+2, p.Bwd, c)
+		switch *outFormat {
+		case "fastq":
+			fwd.Write(buf1)
+			bwd.Write(buf2)
+		case "sam":
+			for _, s := range samPair(p, c, samRefName(fwd.Name)) {
+				s.Write(buf1)
+			}
+		case "bam":
+			for _, s := range samPair(p, c, samRefName(fwd.Name)) {
+				if err := bamRecord(buf1, s, c.ref); err != nil {
+					return err
+				}
+			}
+		}
+		if *truthOutput {
+			writeTruth(tbuf, p, c)
+		}
+		fragDone()
+	}
+	return nil
+}
+
+// Simulates the single-end reads of a chunk into the given buffers.
+// fragDone is called after each read.
+func simulateSingles(c *readChunk, m *model.Model, opts model.Options,
+	rng *rand.Rand, buf, tbuf *bytes.Buffer, fragDone func()) error {
+	for i := range c.n {
+		var r *model.Read
+		if c.circ {
+			r = m.SimulateSingleCircular(c.seq, rng, opts)
+		} else {
+			r = m.SimulateSingle(c.seq[c.start:c.end], rng, opts)
+			shiftRead(r, c.start)
+		}
+		if len(r.Sequence) != m.ReadLen {
+			return fmt.Errorf("bad read length: %d, want %d",
+				len(r.Sequence), m.ReadLen)
+		}
+		r.Name = readName(c.id+i+1, r, c)
+		switch *outFormat {
+		case "fastq":
+			r.Write(buf)
+		case "sam":
+			for _, s := range samSingle(r, c, samRefName(r.Name)) {
+				s.Write(buf)
+			}
+		case "bam":
+			for _, s := range samSingle(r, c, samRefName(r.Name)) {
+				if err := bamRecord(buf, s, c.ref); err != nil {
+					return err
+				}
+			}
+		}
+		if *truthOutput {
+			writeSingleTruth(tbuf, r, c)
+		}
+		fragDone()
+	}
+	return nil
+}
+
+// Shifts a read's coordinates by the given offset, for reads simulated
+// from a part of a contig.
+func shiftRead(r *model.Read, offset int) {
+	if offset == 0 {
+		return
+	}
+	r.Start += offset
+	r.End += offset
+	r.Name = fmt.Append(nil, r.Start+1)
+}
+
+// Returns the output name of a read: its serial number, start position,
+// strand and contig name.
+func readName(id int, r *model.Read, c *readChunk) []byte {
+	return fmt.Appendf(nil, "%d.%s.%c.%s", id, r.Name, strand(r.Reverse), c.name)
+}
+
+// Returns the number of reads simulated from each fragment.
+func readsPerFragment() int {
+	if *singleEnd {
+		return 1
+	}
+	return 2
+}
+
+// Returns the minimal length of a sequence to simulate reads from.
+func minSeqLen(m *model.Model) int {
+	return readsPerFragment() * m.ReadLen
+}
+
+// Output files of the simulated reads.
+type outputs struct {
+	r1, r2 *aio.Writer // Same writer if single output or SAM
+	truth  *aio.Writer // Nil if not requested
+	shuf   *shuffler   // Nil if not shuffling
+}
+
+// Creates the output files with the given prefix.
+// Files are opened raw since chunks arrive already compressed.
+// lens are used for the SAM header, and readLen for sizing the shuffle
+// buckets.
+func createOutputs(prefix string, lens []lenGroup, readLen int) (*outputs,
+	error) {
+	o := &outputs{}
+	var err error
+	switch {
+	case *outFormat == "sam":
+		if o.r1, err = aio.CreateRaw(prefix + ".sam"); err != nil {
+			return nil, err
+		}
+		o.r2 = o.r1
+		if _, err := o.r1.Write(samHeader(lens)); err != nil {
+			return nil, err
+		}
+	case *outFormat == "bam":
+		if o.r1, err = aio.CreateRaw(prefix + ".bam"); err != nil {
+			return nil, err
+		}
+		o.r2 = o.r1
+		header, err := bgzfBytes(bamHeader(samHeader(lens), lens))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := o.r1.Write(header); err != nil {
+			return nil, err
+		}
+	case *singleOutput || *singleEnd:
+		if o.r1, err = aio.CreateRaw(prefix + ".fastq.gz"); err != nil {
+			return nil, err
+		}
+		o.r2 = o.r1
+	default:
+		if o.r1, err = aio.CreateRaw(prefix + "_R1.fastq.gz"); err != nil {
+			return nil, err
+		}
+		if o.r2, err = aio.CreateRaw(prefix + "_R2.fastq.gz"); err != nil {
+			return nil, err
+		}
+	}
+	if *truthOutput {
+		if o.truth, err = aio.CreateRaw(prefix + "_truth.tsv.gz"); err != nil {
+			return nil, err
+		}
+		header, err := gzipBytes(truthHeaderLine())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := o.truth.Write(header); err != nil {
+			return nil, err
+		}
+	}
+	if *shuffle {
+		if o.shuf, err = newShuffler(prefix, numShuffleBuckets(readLen)); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Writes a simulated chunk to the output files, or to the shuffler's
+// buckets if shuffling.
+func (o *outputs) write(out *chunkOutput) error {
+	if out.frames != nil {
+		return o.shuf.add(out.frames)
+	}
+	if _, err := o.r1.Write(out.r1); err != nil {
+		return err
+	}
+	if out.r2 != nil {
+		if _, err := o.r2.Write(out.r2); err != nil {
+			return err
+		}
+	}
+	if out.truth != nil {
+		if _, err := o.truth.Write(out.truth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Closes the output files.
+func (o *outputs) close() error {
+	if *outFormat == "bam" {
+		if _, err := o.r1.Write(bgzfEOF); err != nil {
+			return err
+		}
+	}
+	if err := o.r1.Close(); err != nil {
+		return err
+	}
+	if o.r2 != o.r1 {
+		if err := o.r2.Close(); err != nil {
+			return err
+		}
+	}
+	if o.truth != nil {
+		if err := o.truth.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns b compressed as a standalone gzip member.
+// Concatenated members make a valid gzip file.
+func gzipBytes(b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, 1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fluhus/izzy/model"
+)
+
+func TestSimulate_threads(t *testing.T) {
+	defer func(in []string, n, th int, tr, sh bool, f string) {
+		inFiles, *nReads, *threads, *truthOutput, *shuffle, *outFormat =
+			in, n, th, tr, sh, f
+	}(inFiles, *nReads, *threads, *truthOutput, *shuffle, *outFormat)
+
+	// Enough reads for several chunks per contig.
+	fa := &bytes.Buffer{}
+	r := rand.New(rand.NewPCG(1, 1))
+	for i := range 2 {
+		fmt.Fprintf(fa, ">c%d\n", i)
+		for range 10000 {
+			fa.WriteByte("ACGT"[r.IntN(4)])
+		}
+		fa.WriteByte('\n')
+	}
+	in := filepath.Join(t.TempDir(), "in.fa")
+	if err := os.WriteFile(in, fa.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	inFiles, *nReads, *truthOutput = []string{in}, 24000, true
+
+	lens, err := readSequenceLens(inFiles, *re)
+	if err != nil {
+		t.Fatalf("readSequenceLens(...) failed: %v", err)
+	}
+	groupLens := map[string]int{}
+	groupRatios := map[string]float64{}
+	for _, x := range lens {
+		groupLens[x.g] += x.n
+		groupRatios[x.g] = 1.0 / float64(len(lens))
+	}
+
+	tests := []struct {
+		format  string
+		shuffle bool
+	}{
+		{"fastq", false},
+		{"fastq", true},
+		{"sam", false},
+	}
+	for _, test := range tests {
+		*outFormat, *shuffle = test.format, test.shuffle
+		var want map[string][]byte
+		for _, th := range []int{1, 4} {
+			*threads = th
+			dir := t.TempDir()
+			rng = rand.New(rand.NewPCG(2, 0))
+			if _, err := simulate(filepath.Join(dir, "out"), model.HiSeqModel,
+				model.Options{}, lens, nil, groupLens, groupRatios); err != nil {
+				t.Fatalf("simulate(%v, t=%d) failed: %v", test, th, err)
+			}
+			got := readDir(t, dir)
+			if want == nil {
+				want = got
+				continue
+			}
+			if len(got) != len(want) {
+				t.Fatalf("simulate(%v, t=%d) wrote %d files, want %d",
+					test, th, len(got), len(want))
+			}
+			for name, b := range want {
+				if !bytes.Equal(got[name], b) {
+					t.Errorf("simulate(%v): %s differs between t=1 and t=%d",
+						test, name, th)
+				}
+			}
+		}
+	}
+}
+
+// Returns the contents of the files in a directory, by name.
+func readDir(t *testing.T, dir string) map[string][]byte {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string][]byte{}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result[e.Name()] = b
+	}
+	return result
+}
+
+// Checks that the shuffle buckets are removed when simulation fails.
+func TestSimulate_shuffleError(t *testing.T) {
+	defer func(in []string, sh bool) {
+		inFiles, *shuffle = in, sh
+	}(inFiles, *shuffle)
+	inFiles, *shuffle = []string{filepath.Join(t.TempDir(), "nope.fa")}, true
+
+	dir := t.TempDir()
+	if _, err := simulate(filepath.Join(dir, "out"), model.HiSeqModel,
+		model.Options{}, nil, nil, nil, nil); err == nil {
+		t.Fatalf("simulate(...) succeeded, want error")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			t.Errorf("simulate(...) left %s behind", e.Name())
+		}
+	}
+}
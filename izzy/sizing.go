@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/gostuff/snm"
+	"github.com/fluhus/izzy/model"
+	"golang.org/x/exp/maps"
+)
+
+// Sizing of the sample by read count, coverage or total bases.
+
+// Modes of coverage-based sizing.
+const (
+	coverageAbundance = "abundance" // Coverage follows the abundances
+	coverageUniform   = "uniform"   // All genomes get the same coverage
+)
+
+// Multipliers of base count suffixes.
+var baseSuffixes = map[string]float64{
+	"":  1,
+	"K": 1e3,
+	"M": 1e6,
+	"G": 1e9,
+	"T": 1e12,
+}
+
+// Parses a number of bases, like 5G, 1.5Gbp or 300M.
+func parseBases(s string) (float64, error) {
+	t := strings.ToUpper(s)
+	t = strings.TrimSuffix(t, "BP")
+	t = strings.TrimSuffix(t, "B")
+	mul := 1.0
+	if t != "" {
+		if m, ok := baseSuffixes[t[len(t)-1:]]; ok {
+			mul = m
+			t = t[:len(t)-1]
+		}
+	}
+	f, err := strconv.ParseFloat(t, 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("bad number of bases: %q", s)
+	}
+	return f * mul, nil
+}
+
+// Returns the number of sample size flags that were given.
+func numSizeFlags() int {
+	n := 0
+	for _, given := range []bool{*nReads != 0, *coverage != 0,
+		*totalBases != ""} {
+		if given {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the number of fragments (read pairs or single reads) to
+// simulate, according to the sizing flags. fileReads is the number of
+// reads set by the abundance file, or 0 if it has relative abundances.
+func fragmentCount(m *model.Model, groupLens map[string]int,
+	groupRatios map[string]float64, fileReads float64) (int, error) {
+	if fileReads != 0 && numSizeFlags() != 0 {
+		return 0, fmt.Errorf("-n, -c and -bases cannot be used with read " +
+			"counts or coverage from an abundance file")
+	}
+	if fileReads == 0 && numSizeFlags() == 0 {
+		return 0, fmt.Errorf("need exactly one of -n, -c and -bases")
+	}
+	var nreads float64
+	switch {
+	case fileReads != 0:
+		nreads = fileReads
+	case *totalBases != "":
+		bases, err := parseBases(*totalBases)
+		if err != nil {
+			return 0, err
+		}
+		nreads = bases / float64(m.ReadLen)
+	case *coverage != 0:
+		nreads = coverageReads(*coverage, m.ReadLen, groupLens, groupRatios)
+	default:
+		nreads = float64(*nReads)
+	}
+	n := int(math.Ceil(nreads))
+	if !*singleEnd {
+		n = (n + 1) / 2 // We will create nreads/2 pairs.
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("sample size is less than one read")
+	}
+	return n, nil
+}
+
+// Returns the number of reads that gives the requested mean coverage
+// over the genomes that have reads. groupRatios are the fractions of
+// reads of each group.
+func coverageReads(cov float64, readLen int, groupLens map[string]int,
+	groupRatios map[string]float64) float64 {
+	// The coverage of each group is nreads*ratio*readLen/len.
+	perRead, k := 0.0, 0
+	for _, g := range snm.Sorted(maps.Keys(groupRatios)) {
+		if groupRatios[g] == 0 {
+			continue
+		}
+		perRead += groupRatios[g] * float64(readLen) / float64(groupLens[g])
+		k++
+	}
+	return cov * float64(k) / perRead
+}
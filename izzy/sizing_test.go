@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseBases(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"1000", 1000},
+		{"5G", 5e9},
+		{"1.5Gbp", 1.5e9},
+		{"300m", 3e8},
+		{"2kb", 2000},
+		{"7b", 7},
+	}
+	for _, test := range tests {
+		got, err := parseBases(test.s)
+		if err != nil {
+			t.Fatalf("parseBases(%q) failed: %v", test.s, err)
+		}
+		if got != test.want {
+			t.Errorf("parseBases(%q)=%v, want %v", test.s, got, test.want)
+		}
+	}
+	for _, s := range []string{"", "G", "5X", "-5G", "0", "5GG"} {
+		if got, err := parseBases(s); err == nil {
+			t.Errorf("parseBases(%q)=%v, want error", s, got)
+		}
+	}
+}
+
+func TestCoverageReads(t *testing.T) {
+	lens := map[string]int{"a": 1000, "b": 3000, "c": 5000}
+	tests := []struct {
+		ratios map[string]float64
+		want   float64
+	}{
+		// Uniform coverage: 10x of 4000 bases, in reads of 100.
+		{map[string]float64{"a": 0.25, "b": 0.75}, 400},
+		// Coverages 1:3, mean 10x: a gets 5x (50 reads), b gets 15x (450).
+		{map[string]float64{"a": 0.1, "b": 0.9, "c": 0}, 500},
+	}
+	for _, test := range tests {
+		got := coverageReads(10, 100, lens, test.ratios)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("coverageReads(10,100,%v)=%v, want %v",
+				test.ratios, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fluhus/gostuff/aio"
+	"github.com/fluhus/gostuff/snm"
+	"golang.org/x/exp/maps"
+)
+
+// Report of what was simulated from each group.
+
+// Columns of the summary table.
+const summaryHeader = "group\tabundance\tread_fraction\tfragments\treads\t" +
+	"bases\tcoverage\n"
+
+// A row of the summary table.
+type summaryRow struct {
+	group     string
+	abundance float64 // Relative abundance, in the terms of the input
+	fraction  float64 // Expected fraction of the fragments
+	fragments int     // Simulated fragments
+	bases     int     // Simulated read bases
+	coverage  float64 // Mean coverage of the group's sequences
+}
+
+// Returns the summary rows of all groups, sorted by name.
+// weighted tells whether groupRatios were weighted by length.
+// fragments has the number of simulated fragments of each group.
+func summaryRows(groupLens map[string]int, groupRatios map[string]float64,
+	weighted bool, fragments map[string]int, readLen int) []summaryRow {
+	// Undo the length weighting to get the abundances back.
+	abnd := map[string]float64{}
+	for g, r := range groupRatios {
+		abnd[g] = r
+		if weighted {
+			abnd[g] /= float64(groupLens[g])
+		}
+	}
+	sum := sortedSum(abnd)
+
+	var rows []summaryRow
+	for _, g := range snm.Sorted(maps.Keys(groupLens)) {
+		row := summaryRow{
+			group:     g,
+			fraction:  groupRatios[g],
+			fragments: fragments[g],
+			bases:     fragments[g] * readsPerFragment() * readLen,
+		}
+		if sum > 0 {
+			row.abundance = abnd[g] / sum
+		}
+		row.coverage = float64(row.bases) / float64(groupLens[g])
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Writes the summary table to the given file.
+func writeSummary(file string, rows []summaryRow) error {
+	fout, err := aio.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(fout, summaryHeader); err != nil {
+		fout.Close()
+		return err
+	}
+	for _, r := range rows {
+		if _, err := fmt.Fprintf(fout, "%s\t%.10f\t%.10f\t%d\t%d\t%d\t%.4f\n",
+			r.group, r.abundance, r.fraction, r.fragments,
+			r.fragments*readsPerFragment(), r.bases, r.coverage); err != nil {
+			fout.Close()
+			return err
+		}
+	}
+	return fout.Close()
+}
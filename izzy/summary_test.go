@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSummaryRows(t *testing.T) {
+	lens := map[string]int{"a": 1000, "b": 3000, "c": 500}
+	ratios := map[string]float64{"a": 0.25, "b": 0.75}
+	frags := map[string]int{"a": 50, "b": 150}
+	tests := []struct {
+		weighted bool
+		want     []summaryRow
+	}{
+		// Equal abundances, weighted by length.
+		{true, []summaryRow{
+			{"a", 0.5, 0.25, 50, 10000, 10},
+			{"b", 0.5, 0.75, 150, 30000, 10},
+			{"c", 0, 0, 0, 0, 0},
+		}},
+		// Read fractions, like counts or Bracken.
+		{false, []summaryRow{
+			{"a", 0.25, 0.25, 50, 10000, 10},
+			{"b", 0.75, 0.75, 150, 30000, 10},
+			{"c", 0, 0, 0, 0, 0},
+		}},
+	}
+	for _, test := range tests {
+		got := summaryRows(lens, ratios, test.weighted, frags, 100)
+		for i := range got {
+			if math.Abs(got[i].abundance-test.want[i].abundance) < 1e-9 {
+				got[i].abundance = test.want[i].abundance
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("summaryRows(%v,%v,%v,%v,100)=%v, want %v",
+				lens, ratios, test.weighted, frags, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fluhus/gostuff/aio"
+	"github.com/fluhus/gostuff/csvdec"
+	"github.com/fluhus/gostuff/iterx"
+	"github.com/fluhus/gostuff/snm"
+	"golang.org/x/exp/maps"
+)
+
+// NCBI taxonomy and gold-standard profiles in the CAMI format.
+
+// Ranks of CAMI profiles, from the top.
+var camiRanks = []string{
+	"superkingdom", "phylum", "class", "order", "family", "genus",
+	"species", "strain",
+}
+
+// Index of the strain rank, where taxa below species that have no
+// standard rank are reported.
+var strainRank = len(camiRanks) - 1
+
+// Ranks of newer taxdumps that stand for CAMI ranks.
+var ncbiRankAliases = map[string]string{"domain": "superkingdom"}
+
+// Maximal depth of a lineage, to catch cycles in a bad taxdump.
+const maxLineageDepth = 1000
+
+// A node in the NCBI taxonomy.
+type taxNode struct {
+	parent int
+	rank   string
+}
+
+// The parts of an NCBI taxdump that make lineages.
+type taxonomy struct {
+	nodes  map[int]taxNode
+	names  map[int]string // Scientific names of the needed taxa only
+	merged map[int]int    // Old taxon IDs to new ones
+}
+
+// Reads a TSV file of group names and their NCBI taxon IDs.
+func readTaxidMap(file string) (map[string]int, error) {
+	type entry struct {
+		Group string
+		Taxid int
+	}
+	result := map[string]int{}
+	for row, err := range csvdec.File[entry](file, toTSV) {
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := result[row.Group]; ok && t != row.Taxid {
+			return nil, fmt.Errorf("group %q is mapped to both %d and %d",
+				row.Group, t, row.Taxid)
+		}
+		result[row.Group] = row.Taxid
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no groups in taxid map")
+	}
+	return result, nil
+}
+
+// Loads the taxonomy from an NCBI taxdump directory. Names are loaded
+// only for the lineages of the given taxa.
+func loadTaxonomy(dir string, taxids []int) (*taxonomy, error) {
+	tax := &taxonomy{nodes: map[int]taxNode{}, names: map[int]string{},
+		merged: map[int]int{}}
+	ranks := map[string]string{} // For sharing rank strings.
+	err := readDmp(filepath.Join(dir, "nodes.dmp"), 3, func(f []string) error {
+		id, err1 := strconv.Atoi(f[0])
+		parent, err2 := strconv.Atoi(f[1])
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("bad node: %q", f)
+		}
+		if _, ok := ranks[f[2]]; !ok {
+			ranks[f[2]] = f[2]
+		}
+		tax.nodes[id] = taxNode{parent, ranks[f[2]]}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Merged taxa are optional.
+	err = readDmp(filepath.Join(dir, "merged.dmp"), 2, func(f []string) error {
+		from, err1 := strconv.Atoi(f[0])
+		to, err2 := strconv.Atoi(f[1])
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("bad merged node: %q", f)
+		}
+		tax.merged[from] = to
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	needed := map[int]bool{}
+	for _, id := range taxids {
+		lin, err := tax.lineage(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range lin {
+			needed[x] = true
+		}
+	}
+	err = readDmp(filepath.Join(dir, "names.dmp"), 4, func(f []string) error {
+		if f[3] != "scientific name" {
+			return nil
+		}
+		id, err := strconv.Atoi(f[0])
+		if err != nil {
+			return fmt.Errorf("bad name: %q", f)
+		}
+		if needed[id] {
+			tax.names[id] = f[1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tax, nil
+}
+
+// Calls fn with the fields of each line of a taxdump file, which should
+// have at least n fields.
+func readDmp(file string, n int, fn func([]string) error) error {
+	for line, err := range iterx.LinesFile(file) {
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(strings.TrimSuffix(line, "\t|"), "\t|\t")
+		if len(fields) < n {
+			return fmt.Errorf("%s: expected at least %d fields, found %d: %q",
+				filepath.Base(file), n, len(fields), line)
+		}
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+	}
+	return nil
+}
+
+// Returns the taxon IDs from the given taxon up to the root, after
+// resolving merged IDs.
+func (t *taxonomy) lineage(id int) ([]int, error) {
+	if to, ok := t.merged[id]; ok {
+		id = to
+	}
+	var result []int
+	for range maxLineageDepth {
+		node, ok := t.nodes[id]
+		if !ok {
+			if len(result) == 0 {
+				return nil, fmt.Errorf("taxid %d is not in the taxonomy", id)
+			}
+			return nil, fmt.Errorf("taxid %d has a missing parent: %d",
+				result[0], id)
+		}
+		result = append(result, id)
+		if node.parent == id {
+			return result, nil
+		}
+		id = node.parent
+	}
+	return nil, fmt.Errorf("taxid %d has a lineage of over %d taxa",
+		result[0], maxLineageDepth)
+}
+
+// Returns the index of the taxon's rank in camiRanks, or -1.
+func (t *taxonomy) camiRank(id int) int {
+	rank := t.nodes[id].rank
+	if alias, ok := ncbiRankAliases[rank]; ok {
+		rank = alias
+	}
+	return slices.Index(camiRanks, rank)
+}
+
+// A row of a CAMI profile.
+type camiRow struct {
+	taxid     int
+	rank      int      // Index in camiRanks
+	path      []string // Taxon IDs from the top rank, empty for missing ranks
+	pathNames []string
+	abundance float64
+}
+
+// Returns the profile rows of the given group abundances, with the
+// abundance of each taxon summed over the groups under it.
+// Groups with zero abundance may be missing from taxids.
+func goldStandardProfile(abnd map[string]float64, taxids map[string]int,
+	tax *taxonomy) ([]*camiRow, error) {
+	sum := sortedSum(abnd)
+	rows := map[int]*camiRow{}
+	for _, g := range snm.Sorted(maps.Keys(abnd)) {
+		if abnd[g] == 0 {
+			continue
+		}
+		taxid, ok := taxids[g]
+		if !ok {
+			return nil, fmt.Errorf("group %q is not in the taxid map", g)
+		}
+		lin, err := tax.lineage(taxid)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %w", g, err)
+		}
+
+		// Taxa of the lineage by rank.
+		byRank := make([]int, len(camiRanks))
+		for _, id := range lin {
+			if r := tax.camiRank(id); r != -1 && byRank[r] == 0 {
+				byRank[r] = id
+			}
+		}
+		if tax.camiRank(lin[0]) == -1 && byRank[strainRank-1] != 0 &&
+			byRank[strainRank] == 0 {
+			byRank[strainRank] = lin[0]
+		}
+
+		for r, id := range byRank {
+			if id == 0 {
+				continue
+			}
+			row, ok := rows[id]
+			if !ok {
+				row = &camiRow{taxid: id, rank: r}
+				for _, x := range byRank[:r+1] {
+					if x == 0 {
+						row.path = append(row.path, "")
+						row.pathNames = append(row.pathNames, "")
+					} else {
+						row.path = append(row.path, strconv.Itoa(x))
+						row.pathNames = append(row.pathNames, tax.names[x])
+					}
+				}
+				rows[id] = row
+			}
+			row.abundance += abnd[g] / sum
+		}
+	}
+
+	result := maps.Values(rows)
+	slices.SortFunc(result, func(a, b *camiRow) int {
+		if a.rank != b.rank {
+			return a.rank - b.rank
+		}
+		if a.abundance != b.abundance {
+			if a.abundance > b.abundance {
+				return -1
+			}
+			return 1
+		}
+		return a.taxid - b.taxid
+	})
+	return result, nil
+}
+
+// Writes a CAMI profile with the given rows and sample ID.
+func writeCAMIProfile(file, sample string, rows []*camiRow) error {
+	fout, err := aio.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(fout, "# Taxonomic profile of simulated "+
+		"sample\n@SampleID:%s\n@Version:0.9.1\n@Ranks:%s\n"+
+		"@TaxonomyID:ncbi-taxonomy\n"+
+		"@@TAXID\tRANK\tTAXPATH\tTAXPATHSN\tPERCENTAGE\n",
+		sample, strings.Join(camiRanks, "|")); err != nil {
+		fout.Close()
+		return err
+	}
+	for _, r := range rows {
+		if _, err := fmt.Fprintf(fout, "%d\t%s\t%s\t%s\t%.6f\n",
+			r.taxid, camiRanks[r.rank], strings.Join(r.path, "|"),
+			strings.Join(r.pathNames, "|"), r.abundance*100); err != nil {
+			fout.Close()
+			return err
+		}
+	}
+	return fout.Close()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testNodes = `1	|	1	|	no rank	|
+131567	|	1	|	cellular root	|
+2	|	131567	|	domain	|
+1224	|	2	|	phylum	|
+1236	|	1224	|	class	|
+91347	|	1236	|	order	|
+543	|	91347	|	family	|
+561	|	543	|	genus	|
+562	|	561	|	species	|
+511145	|	562	|	no rank	|
+590	|	1236	|	genus	|
+28901	|	590	|	species	|
+`
+	testNames = `1	|	root	|		|	scientific name	|
+2	|	Bacteria	|	Bacteria <bacteria>	|	scientific name	|
+2	|	eubacteria	|		|	genbank common name	|
+1224	|	Pseudomonadota	|		|	scientific name	|
+1236	|	Gammaproteobacteria	|		|	scientific name	|
+91347	|	Enterobacterales	|		|	scientific name	|
+543	|	Enterobacteriaceae	|		|	scientific name	|
+561	|	Escherichia	|		|	scientific name	|
+562	|	Escherichia coli	|		|	scientific name	|
+511145	|	Escherichia coli K-12 MG1655	|		|	scientific name	|
+590	|	Salmonella	|		|	scientific name	|
+28901	|	Salmonella enterica	|		|	scientific name	|
+`
+	testMerged = "999\t|\t562\t|\n"
+)
+
+func TestGoldStandardProfile(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"nodes.dmp": testNodes, "names.dmp": testNames,
+		"merged.dmp": testMerged,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data),
+			0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	taxids := map[string]int{"a": 511145, "b": 999, "c": 28901, "d": 12345}
+	tax, err := loadTaxonomy(dir, []int{511145, 999, 28901})
+	if err != nil {
+		t.Fatalf("loadTaxonomy failed: %v", err)
+	}
+
+	abnd := map[string]float64{"a": 0.2, "b": 0.3, "c": 0.5, "d": 0}
+	rows, err := goldStandardProfile(abnd, taxids, tax)
+	if err != nil {
+		t.Fatalf("goldStandardProfile(%v) failed: %v", abnd, err)
+	}
+	type row struct {
+		taxid     int
+		rank      string
+		path      string
+		name      string
+		abundance float64
+	}
+	want := []row{
+		{2, "superkingdom", "2", "Bacteria", 1},
+		{1224, "phylum", "2|1224", "Pseudomonadota", 1},
+		{1236, "class", "2|1224|1236", "Gammaproteobacteria", 1},
+		{91347, "order", "2|1224|1236|91347", "Enterobacterales", 0.5},
+		{543, "family", "2|1224|1236|91347|543", "Enterobacteriaceae", 0.5},
+		{561, "genus", "2|1224|1236|91347|543|561", "Escherichia", 0.5},
+		{590, "genus", "2|1224|1236|||590", "Salmonella", 0.5},
+		{562, "species", "2|1224|1236|91347|543|561|562", "Escherichia coli",
+			0.5},
+		{28901, "species", "2|1224|1236|||590|28901", "Salmonella enterica",
+			0.5},
+		{511145, "strain", "2|1224|1236|91347|543|561|562|511145",
+			"Escherichia coli K-12 MG1655", 0.2},
+	}
+	var got []row
+	for _, r := range rows {
+		names := r.pathNames
+		got = append(got, row{r.taxid, camiRanks[r.rank],
+			strings.Join(r.path, "|"), names[len(names)-1],
+			math.Round(r.abundance*1e9) / 1e9})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("goldStandardProfile(%v)=\n%v, want\n%v", abnd, got, want)
+	}
+
+	abnd["d"] = 0.1
+	if got, err := goldStandardProfile(abnd, taxids, tax); err == nil {
+		t.Errorf("goldStandardProfile(%v)=%v, want error", abnd, got)
+	}
+}
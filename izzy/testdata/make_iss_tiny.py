@@ -0,0 +1,69 @@
+"""Writes iss_tiny.npz, a tiny InSilicoSeq KDE model, with numpy.
+
+The arrays have the layout of InSilicoSeq's models: object arrays of
+per-position dicts and cumulative quality distributions.
+
+Usage: python3 make_iss_tiny.py [output]
+"""
+
+import sys
+
+import numpy as np
+
+NUCS = 'ACGT'
+
+
+def subst(probs):
+    """Returns per-position substitution choices, as InSilicoSeq has them."""
+    return np.array([
+        {n: (np.array([x for x in NUCS if x != n]), np.array(p[n]))
+         for n in NUCS} for p in probs
+    ], dtype=object)
+
+
+def per_nuc(values):
+    """Returns per-position dicts of a value for each nucleotide."""
+    return np.array([dict(zip(NUCS, v)) for v in values], dtype=object)
+
+
+def quality_hist(cdfs):
+    """Returns per-bin, per-position cumulative quality distributions."""
+    result = np.empty((len(cdfs), 3), dtype=object)
+    for i, cdf in enumerate(cdfs):
+        for j in range(3):
+            result[i, j] = np.array(cdf)
+    return result
+
+
+def main():
+    out = sys.argv[1] if len(sys.argv) > 1 else 'iss_tiny.npz'
+    same = [0.1, 0.3, 0.6]
+    zero_g = {'A': [0.2, 0.3, 0.6], 'C': [0.2, 0.3, 0.6],
+              'G': [0.0, 0.0, 0.0], 'T': [0.2, 0.3, 0.6]}
+    third = [0.1 + 0.2, 0.3, 0.6]
+    np.savez_compressed(
+        out,
+        model=np.array('kde'),
+        read_length=np.array(3),
+        insert_size=np.array([0.0, 0.25, 0.5, 1.0]),
+        mean_count_forward=np.array([3, 1]),
+        mean_count_reverse=np.array([1, 1]),
+        quality_hist_forward=quality_hist([[0.5, 1.0], [0.1, 0.2, 1.0]]),
+        quality_hist_reverse=quality_hist([[0.1, 0.2, 1.0], [0.5, 1.0]]),
+        subst_choices_forward=subst([
+            dict.fromkeys(NUCS, same), zero_g, dict.fromkeys(NUCS, third)]),
+        subst_choices_reverse=subst([
+            dict.fromkeys(NUCS, third), zero_g, dict.fromkeys(NUCS, same)]),
+        ins_forward=per_nuc([[0.001, 0.002, 0.0, 1e-5],
+                             [0.002, 0.002, 0.0, 1e-5],
+                             [0.003, 0.002, 0.0, 1e-5]]),
+        ins_reverse=per_nuc([[0.001, 0.002, 0.0, 1e-5],
+                             [0.002, 0.002, 0.0, 1e-5],
+                             [0.003, 0.002, 0.0, 1e-5]]),
+        del_forward=per_nuc([[0.01, 0.02, 0.03, 0.04]] * 3),
+        del_reverse=per_nuc([[0.01, 0.02, 0.03, 0.04]] * 3),
+    )
+
+
+if __name__ == '__main__':
+    main()
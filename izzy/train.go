@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/fluhus/biostuff/formats/sam"
+	"github.com/fluhus/biostuff/sequtil"
+)
+
+// Training of error models from real reads aligned to a reference.
+
+const (
+	// Number of mean-quality bins, each spanning trainQualBinWidth phred
+	// values. The last bin includes everything above it.
+	trainQualBins     = 4
+	trainQualBinWidth = 10
+
+	// Maximal phred score, so that models stay within phredToProb.
+	trainMaxPhred = 99
+
+	// Insert sizes above this value are ignored.
+	trainMaxInsert = 10000
+
+	// Number of reads used for inferring the read length.
+	trainPeekReads = 10000
+)
+
+// Accumulated statistics of one mate (forward or reverse).
+type mateStats struct {
+	meanCount []int       // Reads per mean-quality bin
+	qual      [][][]int   // Phred counts per bin and cycle
+	subst     [][4][4]int // Mismatch counts per cycle, reference and read base
+	ins       [][4]int    // Inserted bases after each source position
+	del       [][4]int    // Deleted bases at each source position
+	ref       [][4]int    // Aligned reference bases at each source position
+}
+
+// Returns empty statistics for reads of length n.
+func newMateStats(n int) *mateStats {
+	return &mateStats{
+		meanCount: make([]int, trainQualBins),
+		qual:      make([][][]int, trainQualBins),
+		subst:     make([][4][4]int, n),
+		ins:       make([][4]int, n),
+		del:       make([][4]int, n),
+		ref:       make([][4]int, n),
+	}
+}
+
+// Accumulated statistics for training a model.
+type trainStats struct {
+	readLen int
+	insert  []int          // Counts of insert sizes
+	mates   [2]*mateStats  // Forward and reverse
+	used    int            // Number of reads that were used
+	skipped map[string]int // Number of skipped reads, by reason
+}
+
+// Returns empty statistics for reads of length n.
+func newTrainStats(n int) *trainStats {
+	return &trainStats{
+		readLen: n,
+		mates:   [2]*mateStats{newMateStats(n), newMateStats(n)},
+		skipped: map[string]int{},
+	}
+}
+
+// Collects training statistics from aligned reads. refs maps reference
+// names to their sequences. If readLen is 0, the most common read length
+// is used.
+func trainFromSAM(reads iter.Seq2[*sam.SAM, error], refs map[string][]byte,
+	readLen int) (*trainStats, error) {
+	var st *trainStats
+	var peek []*sam.SAM
+	for s, err := range reads {
+		if err != nil {
+			return nil, err
+		}
+		if st != nil {
+			if err := st.add(s, refs); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if readLen == 0 && len(peek) < trainPeekReads {
+			peek = append(peek, s)
+			continue
+		}
+		if st, err = startTraining(peek, refs, readLen); err != nil {
+			return nil, err
+		}
+		peek = nil
+		if err := st.add(s, refs); err != nil {
+			return nil, err
+		}
+	}
+	if st == nil {
+		var err error
+		if st, err = startTraining(peek, refs, readLen); err != nil {
+			return nil, err
+		}
+	}
+	if st.used == 0 {
+		return nil, fmt.Errorf("no usable reads, skipped: %v", st.skipped)
+	}
+	return st, nil
+}
+
+// Creates training statistics and adds the peeked reads to them.
+// If readLen is 0, the most common length among the peeked reads is used.
+func startTraining(peek []*sam.SAM, refs map[string][]byte,
+	readLen int) (*trainStats, error) {
+	if readLen == 0 {
+		counts := map[int]int{}
+		for _, s := range peek {
+			if trainable(s) {
+				counts[len(s.Seq)]++
+			}
+		}
+		for n, c := range counts {
+			if c > counts[readLen] || (c == counts[readLen] && n > readLen) {
+				readLen = n
+			}
+		}
+		if readLen == 0 {
+			return nil, fmt.Errorf("no usable reads, need primary alignments " +
+				"of paired reads")
+		}
+	}
+	st := newTrainStats(readLen)
+	for _, s := range peek {
+		if err := st.add(s, refs); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// Checks whether a read should be considered for training.
+func trainable(s *sam.SAM) bool {
+	return s.Flag.Multiple() && !s.Flag.Unmapped() && !s.Flag.Secondary() &&
+		!s.Flag.Supplementary() && !s.Flag.Duplicate() &&
+		!s.Flag.NotPassing() && s.Seq != "*" && s.Qual != "*" &&
+		s.Cigar != "*"
+}
+
+// Adds a read's statistics.
+func (st *trainStats) add(s *sam.SAM, refs map[string][]byte) error {
+	if !trainable(s) {
+		st.skipped["unmapped, unpaired or non-primary"]++
+		return nil
+	}
+	if len(s.Seq) != st.readLen || len(s.Qual) != len(s.Seq) {
+		st.skipped["different read length"]++
+		return nil
+	}
+	ref, ok := refs[s.Rname]
+	if !ok {
+		return fmt.Errorf("read %s: unknown reference: %q", s.Qname, s.Rname)
+	}
+	seq := []byte(s.Seq)
+	ops, err := expandCigar(s.Cigar)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", s.Qname, err)
+	}
+	if n := cigarReadLen(ops); n != len(seq) {
+		return fmt.Errorf("read %s: CIGAR length %d does not match "+
+			"sequence length %d", s.Qname, n, len(seq))
+	}
+	if n := cigarRefLen(ops); s.Pos < 1 || s.Pos-1+n > len(ref) {
+		return fmt.Errorf("read %s: alignment exceeds reference %q",
+			s.Qname, s.Rname)
+	}
+
+	// Bring everything to read orientation.
+	qual := []byte(s.Qual)
+	ref = ref[s.Pos-1 : s.Pos-1+cigarRefLen(ops)]
+	if s.Flag.ReverseComplement() {
+		seq = sequtil.ReverseComplement(nil, seq)
+		ref = sequtil.ReverseComplement(nil, ref)
+		slices.Reverse(qual)
+		slices.Reverse(ops)
+	}
+
+	mate := st.mates[0]
+	if s.Flag.Last() {
+		mate = st.mates[1]
+	}
+	mate.addQuals(qual)
+	mate.addAlignment(seq, ref, ops)
+
+	if s.Flag.First() && s.Flag.Each() && s.Tlen != 0 {
+		ins := max(abs(s.Tlen)-2*st.readLen, 0)
+		if ins <= trainMaxInsert {
+			if ins >= len(st.insert) {
+				st.insert = append(st.insert, make([]int, ins-len(st.insert)+1)...)
+			}
+			st.insert[ins]++
+		}
+	}
+	st.used++
+	return nil
+}
+
+// Adds a read's quality scores, in read orientation.
+func (ms *mateStats) addQuals(qual []byte) {
+	phreds := make([]int, len(qual))
+	total := 0
+	for i, q := range qual {
+		phreds[i] = min(max(int(q)-33, 0), trainMaxPhred)
+		total += phreds[i]
+	}
+	bin := min(total/len(phreds)/trainQualBinWidth, trainQualBins-1)
+	ms.meanCount[bin]++
+	if ms.qual[bin] == nil {
+		ms.qual[bin] = make([][]int, len(qual))
+	}
+	for i, p := range phreds {
+		h := ms.qual[bin][i]
+		if p >= len(h) {
+			h = append(h, make([]int, p-len(h)+1)...)
+			ms.qual[bin][i] = h
+		}
+		h[p]++
+	}
+}
+
+// Adds a read's substitutions and indels. seq and ref are the read and
+// its aligned reference span, and ops are CIGAR operations, all in read
+// orientation.
+func (ms *mateStats) addAlignment(seq, ref, ops []byte) {
+	n := len(ms.ref)
+	iseq, iref := 0, 0 // Read cycle and source position.
+	for _, op := range ops {
+		switch op {
+		case 'M', '=', 'X':
+			r, b := sequtil.Ntoi(ref[iref]), sequtil.Ntoi(seq[iseq])
+			if r != -1 && iref < n {
+				ms.ref[iref][r]++
+			}
+			if r != -1 && b != -1 && r != b {
+				ms.subst[iseq][r][b]++
+			}
+			iseq++
+			iref++
+		case 'I':
+			// Inserted after the previous source base.
+			b := sequtil.Ntoi(seq[iseq])
+			if b != -1 && iref <= n {
+				ms.ins[max(iref-1, 0)][b]++
+			}
+			iseq++
+		case 'D':
+			r := sequtil.Ntoi(ref[iref])
+			if r != -1 && iref < n {
+				ms.ref[iref][r]++
+				ms.del[iref][r]++
+			}
+			iref++
+		case 'N': // Skipped region, not an error.
+			iref++
+		case 'S':
+			iseq++
+		}
+	}
+}
+
+// Expands a CIGAR string to one operation per position.
+func expandCigar(cigar string) ([]byte, error) {
+	var ops []byte
+	n := 0
+	for i := range len(cigar) {
+		c := cigar[i]
+		if c >= '0' && c <= '9' {
+			n = n*10 + int(c-'0')
+			continue
+		}
+		switch c {
+		case 'M', 'I', 'D', 'N', 'S', '=', 'X':
+			for range n {
+				ops = append(ops, c)
+			}
+		case 'H', 'P':
+		default:
+			return nil, fmt.Errorf("bad CIGAR: %q", cigar)
+		}
+		n = 0
+	}
+	if n != 0 {
+		return nil, fmt.Errorf("bad CIGAR: %q", cigar)
+	}
+	return ops, nil
+}
+
+// Returns the number of reference positions covered by expanded CIGAR
+// operations.
+func cigarRefLen(ops []byte) int {
+	n := 0
+	for _, op := range ops {
+		switch op {
+		case 'M', 'D', 'N', '=', 'X':
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the number of read positions covered by expanded CIGAR
+// operations.
+func cigarReadLen(ops []byte) int {
+	n := 0
+	for _, op := range ops {
+		switch op {
+		case 'M', 'I', 'S', '=', 'X':
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the trained model in izzy's JSON structure.
+func (st *trainStats) model(name string) (map[string]any, error) {
+	if len(st.insert) == 0 {
+		return nil, fmt.Errorf("no properly paired reads for insert sizes")
+	}
+	d := map[string]any{
+		"name":      name,
+		"readLen":   st.readLen,
+		"insertLen": countsToCDF(st.insert),
+	}
+	for i, suffix := range []string{"Forward", "Reverse"} {
+		ms := st.mates[i]
+		if sum(ms.meanCount) == 0 {
+			return nil, fmt.Errorf("no %s reads",
+				map[int]string{0: "first-mate", 1: "second-mate"}[i])
+		}
+		d["meanCount"+suffix] = countsToCDF(ms.meanCount)
+		qual := make([][][]float64, trainQualBins)
+		for bin, h := range ms.qual {
+			qual[bin] = make([][]float64, 0, len(h)) // Empty if no reads.
+			for _, counts := range h {
+				qual[bin] = append(qual[bin], countsToCDF(counts))
+			}
+		}
+		d["qualityHist"+suffix] = qual
+
+		subst := make([][4][]float64, st.readLen)
+		ins := make([][4]float64, st.readLen)
+		del := make([][4]float64, st.readLen)
+		for pos := range st.readLen {
+			cover := sum(ms.ref[pos][:])
+			for b := range 4 {
+				counts := ms.subst[pos][b][:]
+				if sum(counts) == 0 { // Unobserved, use uniform.
+					counts = []int{1, 1, 1, 1}
+					counts[b] = 0
+				}
+				subst[pos][b] = countsToCDF(counts)
+				if cover > 0 {
+					ins[pos][b] = float64(ms.ins[pos][b]) / float64(cover)
+				}
+				if ms.ref[pos][b] > 0 {
+					del[pos][b] = float64(ms.del[pos][b]) / float64(ms.ref[pos][b])
+				}
+			}
+		}
+		d["substChoices"+suffix] = subst
+		d["ins"+suffix] = ins
+		d["del"+suffix] = del
+	}
+	return d, nil
+}
+
+// Returns the cumulative distribution of the given counts.
+func countsToCDF(counts []int) []float64 {
+	total := float64(sum(counts))
+	result := make([]float64, len(counts))
+	acc := 0
+	for i, c := range counts {
+		acc += c
+		result[i] = float64(acc) / total
+	}
+	return result
+}
+
+// Returns the absolute value of a number.
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Returns the sum of the given numbers.
+func sum(a []int) int {
+	s := 0
+	for _, x := range a {
+		s += x
+	}
+	return s
+}
+
+// Returns a human-readable summary of the training statistics.
+func (st *trainStats) String() string {
+	return fmt.Sprintf("%d reads of length %d, skipped: %v",
+		st.used, st.readLen, st.skipped)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math/rand/v2"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/sam"
+	"github.com/fluhus/izzy/model"
+)
+
+func TestExpandCigar(t *testing.T) {
+	tests := []struct {
+		cigar string
+		want  string
+	}{
+		{"3M", "MMM"},
+		{"2S1M2I1D1M1H", "SSMIIDM"},
+		{"1=1X10N1M", "=XNNNNNNNNNNM"},
+	}
+	for _, test := range tests {
+		got, err := expandCigar(test.cigar)
+		if err != nil {
+			t.Fatalf("expandCigar(%q) failed: %v", test.cigar, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("expandCigar(%q)=%q, want %q", test.cigar, got, test.want)
+		}
+	}
+	for _, cigar := range []string{"3", "M3", "3Q"} {
+		if got, err := expandCigar(cigar); err == nil {
+			t.Errorf("expandCigar(%q)=%q, want error", cigar, got)
+		}
+	}
+}
+
+func TestMateStats_addAlignment(t *testing.T) {
+	ms := newMateStats(6)
+	ms.addAlignment([]byte("AGTTCA"), []byte("ACGTCA"), []byte("MMDMIMM"))
+	wantSubst := make([][4][4]int, 6)
+	wantSubst[1][1][2] = 1 // C>G at cycle 1.
+	if !reflect.DeepEqual(ms.subst, wantSubst) {
+		t.Errorf("subst=%v, want %v", ms.subst, wantSubst)
+	}
+	wantIns := make([][4]int, 6)
+	wantIns[3][3] = 1 // T after source base 3.
+	if !reflect.DeepEqual(ms.ins, wantIns) {
+		t.Errorf("ins=%v, want %v", ms.ins, wantIns)
+	}
+	wantDel := make([][4]int, 6)
+	wantDel[2][2] = 1 // G at source base 2.
+	if !reflect.DeepEqual(ms.del, wantDel) {
+		t.Errorf("del=%v, want %v", ms.del, wantDel)
+	}
+	wantRef := [][4]int{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0},
+		{0, 0, 0, 1}, {0, 1, 0, 0}, {1, 0, 0, 0}}
+	if !reflect.DeepEqual(ms.ref, wantRef) {
+		t.Errorf("ref=%v, want %v", ms.ref, wantRef)
+	}
+}
+
+// Trains on reads simulated from a known model and compares the results.
+func TestTrainFromSAM(t *testing.T) {
+	const n = 5000
+	rng := rand.New(rand.NewPCG(1, 1))
+	ref := make([]byte, 3000)
+	for i := range ref {
+		ref[i] = "ACGT"[rng.IntN(4)]
+	}
+	m := model.HiSeqModel
+	c := &readChunk{seq: ref, name: []byte("chr1")}
+	reads := func(yield func(*sam.SAM, error) bool) {
+		for range n {
+			p := m.SimulatePair(ref, rng, model.Options{})
+			for _, s := range samPair(p, c, "r") {
+				if !yield(s, nil) {
+					return
+				}
+			}
+		}
+	}
+
+	st, err := trainFromSAM(reads, map[string][]byte{"chr1": ref}, 0)
+	if err != nil {
+		t.Fatalf("trainFromSAM(...) failed: %v", err)
+	}
+	if st.readLen != m.ReadLen {
+		t.Errorf("readLen=%d, want %d", st.readLen, m.ReadLen)
+	}
+	if st.used != 2*n {
+		t.Errorf("used=%d, want %d", st.used, 2*n)
+	}
+	if got := sum(st.mates[1].meanCount); got != n {
+		t.Errorf("reverse reads=%d, want %d", got, n)
+	}
+
+	d, err := st.model("trained")
+	if err != nil {
+		t.Fatalf("model(...) failed: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "trained.json")
+	if err := writeJSON(file, d); err != nil {
+		t.Fatalf("writeJSON(...) failed: %v", err)
+	}
+	got, err := model.Load(file)
+	if err != nil {
+		t.Fatalf("model.Load(%q) failed: %v", file, err)
+	}
+	if got, want := cdfMean(got.InsertLen), cdfMean(m.InsertLen); got < want*0.9 ||
+		got > want*1.1 {
+		t.Errorf("mean insert size=%f, want ~%f", got, want)
+	}
+	if got, want := cdfMean(got.QualityHistForward[3][0]),
+		cdfMean(m.QualityHistForward[3][0]); got < want-1 || got > want+1 {
+		t.Errorf("mean quality=%f, want ~%f", got, want)
+	}
+}
+
+// Returns the mean value of a CDF over indexes.
+func cdfMean(c []float64) float64 {
+	mean, prev := 0.0, 0.0
+	for i, x := range c {
+		mean += float64(i) * (x - prev)
+		prev = x
+	}
+	return mean
+}
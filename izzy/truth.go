@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fluhus/izzy/model"
+)
+
+// Columns of the truth table.
+var truthHeader = []string{
+	"r1_name", "r2_name", "group", "contig", "strand", "fragment_len",
+	"r1_start", "r1_end", "r2_start", "r2_end",
+	"r1_subst", "r1_ins", "r1_del", "r2_subst", "r2_ins", "r2_del",
+}
+
+// Columns of the truth table for single-end reads.
+var singleTruthHeader = []string{
+	"name", "group", "contig", "strand", "start", "end", "subst", "ins", "del",
+}
+
+// Returns the header of the truth table: a comment line with the
+// coordinate convention, followed by the column names.
+func truthHeaderLine() []byte {
+	header := "# positions are 1-based and inclusive, like in SAM\n"
+	if *truthBase == "0" {
+		header = "# positions are 0-based and end-exclusive, like in BED\n"
+	}
+	if *singleEnd {
+		return []byte(header + strings.Join(singleTruthHeader, "\t") + "\n")
+	}
+	return []byte(header + strings.Join(truthHeader, "\t") + "\n")
+}
+
+// Writes a truth table row for the given pair. The strand is that of R1.
+// Reads that span the origin of a circular contig have an end before their
+// start.
+func writeTruth(buf *bytes.Buffer, p *model.Pair, c *readChunk) {
+	start1, end1 := truthSpan(p.Fwd, len(c.seq))
+	start2, end2 := truthSpan(p.Bwd, len(c.seq))
+	fmt.Fprintf(buf, "%s\t%s\t%s\t%s\t%c\t%d\t%d\t%d\t%d\t%d\t"+
+		"%d\t%d\t%d\t%d\t%d\t%d\n",
+		p.Fwd.Name, p.Bwd.Name, c.group, samRefName(c.name),
+		strand(p.Fwd.Reverse), p.FragmentLen, start1, end1, start2, end2,
+		p.Fwd.Subst, p.Fwd.Ins, p.Fwd.Del,
+		p.Bwd.Subst, p.Bwd.Ins, p.Bwd.Del)
+}
+
+// Writes a truth table row for the given single-end read.
+// Positions wrap like in writeTruth.
+func writeSingleTruth(buf *bytes.Buffer, r *model.Read, c *readChunk) {
+	start, end := truthSpan(r, len(c.seq))
+	fmt.Fprintf(buf, "%s\t%s\t%s\t%c\t%d\t%d\t%d\t%d\t%d\n",
+		r.Name, c.group, samRefName(c.name), strand(r.Reverse), start, end,
+		r.Subst, r.Ins, r.Del)
+}
+
+// Returns the start and end of a read on a contig of length n, in the
+// coordinate convention of the truth table.
+func truthSpan(r *model.Read, n int) (int, int) {
+	start, end := wrapPos(r.Start+1, n), wrapPos(r.End, n)
+	if *truthBase == "0" {
+		start--
+	}
+	return start, end
+}
+
+// Returns the strand symbol of a read.
+func strand(reverse bool) byte {
+	if reverse {
+		return '-'
+	}
+	return '+'
+}
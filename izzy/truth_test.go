@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fluhus/biostuff/formats/fastq"
+	"github.com/fluhus/izzy/model"
+)
+
+func TestWriteTruth(t *testing.T) {
+	defer func(b string) { *truthBase = b }(*truthBase)
+	c := &readChunk{seq: make([]byte, 1000), name: []byte("sp0_c0 desc"),
+		group: "sp0"}
+	read := func(name string, start, end int, rev bool) *model.Read {
+		return &model.Read{Fastq: &fastq.Fastq{Name: []byte(name)},
+			Start: start, End: end, Reverse: rev, Subst: 1, Ins: 2, Del: 3}
+	}
+	pair := &model.Pair{
+		Fwd:         read("1.11.+.sp0_c0", 10, 110, false),
+		Bwd:         read("2.301.-.sp0_c0", 300, 400, true),
+		FragmentLen: 390,
+	}
+	wrapped := &model.Pair{ // Spans the origin of a circular contig.
+		Fwd:         read("3.951.-.sp0_c0", 950, 1050, true),
+		Bwd:         read("4.851.+.sp0_c0", 850, 950, false),
+		FragmentLen: 200,
+	}
+	tests := []struct {
+		base string
+		p    *model.Pair
+		want string
+	}{
+		{"1", pair, "1.11.+.sp0_c0\t2.301.-.sp0_c0\tsp0\tsp0_c0\t+\t390\t" +
+			"11\t110\t301\t400\t1\t2\t3\t1\t2\t3\n"},
+		{"0", pair, "1.11.+.sp0_c0\t2.301.-.sp0_c0\tsp0\tsp0_c0\t+\t390\t" +
+			"10\t110\t300\t400\t1\t2\t3\t1\t2\t3\n"},
+		{"1", wrapped, "3.951.-.sp0_c0\t4.851.+.sp0_c0\tsp0\tsp0_c0\t-\t200\t" +
+			"951\t50\t851\t950\t1\t2\t3\t1\t2\t3\n"},
+		{"0", wrapped, "3.951.-.sp0_c0\t4.851.+.sp0_c0\tsp0\tsp0_c0\t-\t200\t" +
+			"950\t50\t850\t950\t1\t2\t3\t1\t2\t3\n"},
+	}
+	for _, test := range tests {
+		*truthBase = test.base
+		buf := &bytes.Buffer{}
+		writeTruth(buf, test.p, c)
+		if got := buf.String(); got != test.want {
+			t.Errorf("writeTruth(base %s)=%q, want %q",
+				test.base, got, test.want)
+		}
+		if got := len(strings.Split(test.want, "\t")); got != len(truthHeader) {
+			t.Errorf("row has %d columns, header has %d",
+				got, len(truthHeader))
+		}
+	}
+
+	*truthBase = "0"
+	buf := &bytes.Buffer{}
+	writeSingleTruth(buf, pair.Bwd, c)
+	want := "2.301.-.sp0_c0\tsp0\tsp0_c0\t-\t300\t400\t1\t2\t3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeSingleTruth(...)=%q, want %q", got, want)
+	}
+	if got := string(truthHeaderLine()); !strings.HasPrefix(got,
+		"# positions are 0-based") {
+		t.Errorf("truthHeaderLine()=%q, want 0-based comment", got)
+	}
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/fluhus/izzy/spec"
+	"golang.org/x/exp/slices"
+)
+
+// Maximal number of fragments to draw for a single one, after which the
+// last one is kept. Prevents endless loops on sequences that the curve
+// rejects entirely.
+const maxGCTries = 1000
+
+// Parameters of the GC curve shapes.
+var gcCurveParams = map[string][]spec.Param{
+	"gaussian": {
+		{Name: "center", Value: 0.5, Check: spec.Fraction},
+		{Name: "width", Value: 0.15, Check: spec.Positive},
+		{Name: "floor", Value: 0.05, Check: spec.Fraction},
+	},
+}
+
+// GCCurve maps the GC fraction of a fragment to the chance of keeping it,
+// for simulating GC coverage bias. Rejected fragments are replaced by
+// others from the same sequence.
+type GCCurve struct {
+	Name string                // Spec of the curve, for reporting
+	f    func(float64) float64 // Values are between 0 and 1
+}
+
+// ParseGCCurve returns the GC curve described by spec, which is a shape
+// optionally followed by parameters in parentheses. The only shape is
+// "gaussian(center=0.5,width=0.15,floor=0.05)", which keeps fragments
+// with GC around center and keeps at least floor of the others.
+func ParseGCCurve(s string) (*GCCurve, error) {
+	name, p, err := spec.Parse(s, "GC curve", gcCurveParams)
+	if err != nil {
+		return nil, err
+	}
+	center, width, floor := p["center"], p["width"], p["floor"]
+	return &GCCurve{
+		Name: spec.Format(name, gcCurveParams[name], p),
+		f: func(gc float64) float64 {
+			z := (gc - center) / width
+			return floor + (1-floor)*math.Exp(-z*z/2)
+		},
+	}, nil
+}
+
+// NewGCCurve returns a piecewise-linear GC curve through the given points,
+// scaled so that its maximum is 1. GC fractions should be increasing
+// and between 0 and 1. The curve is flat beyond the first and last points.
+func NewGCCurve(name string, gc, weight []float64) (*GCCurve, error) {
+	if len(gc) != len(weight) {
+		return nil, fmt.Errorf("mismatching lengths: %d, %d",
+			len(gc), len(weight))
+	}
+	if len(gc) == 0 {
+		return nil, fmt.Errorf("no points")
+	}
+	for i := range gc {
+		if gc[i] < 0 || gc[i] > 1 || math.IsNaN(gc[i]) {
+			return nil, fmt.Errorf("GC fraction %v should be between 0 and 1",
+				gc[i])
+		}
+		if i > 0 && gc[i] <= gc[i-1] {
+			return nil, fmt.Errorf("GC fractions should be increasing: "+
+				"%v after %v", gc[i], gc[i-1])
+		}
+		if weight[i] < 0 || math.IsNaN(weight[i]) || math.IsInf(weight[i], 0) {
+			return nil, fmt.Errorf("bad weight for GC %v: %v", gc[i], weight[i])
+		}
+	}
+	mx := slices.Max(weight)
+	if mx == 0 {
+		return nil, fmt.Errorf("all weights are zero")
+	}
+	gc, weight = slices.Clone(gc), slices.Clone(weight)
+	for i := range weight {
+		weight[i] /= mx
+	}
+	return &GCCurve{
+		Name: name,
+		f: func(x float64) float64 {
+			i, _ := slices.BinarySearch(gc, x)
+			if i == 0 {
+				return weight[0]
+			}
+			if i == len(gc) {
+				return weight[len(gc)-1]
+			}
+			t := (x - gc[i-1]) / (gc[i] - gc[i-1])
+			return weight[i-1] + t*(weight[i]-weight[i-1])
+		},
+	}, nil
+}
+
+// Weight returns the chance of keeping a fragment with the given GC
+// fraction.
+func (c *GCCurve) Weight(gc float64) float64 {
+	return c.f(gc)
+}
+
+// Returns whether to keep the fragment made of the given parts.
+// Always keeps it if c is nil, without using rng.
+func (c *GCCurve) keep(rng *rand.Rand, frag ...[]byte) bool {
+	if c == nil {
+		return true
+	}
+	gc, ok := gcFraction(frag...)
+	if !ok {
+		return true
+	}
+	return rng.Float64() < c.Weight(gc)
+}
+
+// Returns the fraction of G and C among the ACGT bases of the given
+// parts of a sequence, and false if they have none.
+func gcFraction(seq ...[]byte) (float64, bool) {
+	gc, n := 0, 0
+	for _, part := range seq {
+		for _, b := range part {
+			switch b {
+			case 'G', 'C', 'g', 'c':
+				gc++
+				n++
+			case 'A', 'T', 'a', 't':
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return float64(gc) / float64(n), true
+}
+
+// Returns the parts of a circular sequence that make the n bases that
+// start at i, where n is at most len(seq).
+func circularParts(seq []byte, i, n int) [][]byte {
+	if i+n <= len(seq) {
+		return [][]byte{seq[i : i+n]}
+	}
+	return [][]byte{seq[i:], seq[:i+n-len(seq)]}
+}
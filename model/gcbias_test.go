@@ -0,0 +1,107 @@
+package model
+
+import (
+	"bytes"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestParseGCCurve(t *testing.T) {
+	tests := []struct {
+		spec     string
+		gc, want float64
+	}{
+		{"gaussian", 0.5, 1},
+		{"gaussian()", 0.5, 1},
+		{"gaussian(floor=0)", 0.65, math.Exp(-0.5)},
+		{"gaussian(center=0.4, width=0.1, floor=0.2)", 0.4, 1},
+		{"gaussian(center=0.4, width=0.1, floor=0.2)", 0.5,
+			0.2 + 0.8*math.Exp(-0.5)},
+		{"gaussian(floor=1)", 0, 1},
+	}
+	for _, test := range tests {
+		c, err := ParseGCCurve(test.spec)
+		if err != nil {
+			t.Fatalf("ParseGCCurve(%q) failed: %v", test.spec, err)
+		}
+		if got := c.Weight(test.gc); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("ParseGCCurve(%q).Weight(%v)=%v, want %v",
+				test.spec, test.gc, got, test.want)
+		}
+	}
+	for _, spec := range []string{
+		"", "normal", "gaussian(", "gaussian(x=1)", "gaussian(center)",
+		"gaussian(center=2)", "gaussian(width=0)", "gaussian(floor=-1)",
+		"gaussian(width=NaN)",
+	} {
+		if got, err := ParseGCCurve(spec); err == nil {
+			t.Errorf("ParseGCCurve(%q)=%v, want error", spec, got.Name)
+		}
+	}
+}
+
+func TestNewGCCurve(t *testing.T) {
+	c, err := NewGCCurve("table", []float64{0.2, 0.5, 0.8},
+		[]float64{1, 4, 2})
+	if err != nil {
+		t.Fatalf("NewGCCurve failed: %v", err)
+	}
+	tests := []struct {
+		gc, want float64
+	}{
+		{0, 0.25}, {0.2, 0.25}, {0.35, 0.625}, {0.5, 1}, {0.65, 0.75},
+		{0.8, 0.5}, {1, 0.5},
+	}
+	for _, test := range tests {
+		if got := c.Weight(test.gc); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("Weight(%v)=%v, want %v", test.gc, got, test.want)
+		}
+	}
+
+	bad := [][2][]float64{
+		{nil, nil},
+		{{0.1}, {1, 2}},
+		{{0.5, 0.5}, {1, 1}},
+		{{0.5, 0.4}, {1, 1}},
+		{{1.5}, {1}},
+		{{0.5}, {-1}},
+		{{0.5}, {0}},
+	}
+	for _, b := range bad {
+		if _, err := NewGCCurve("table", b[0], b[1]); err == nil {
+			t.Errorf("NewGCCurve(%v,%v) succeeded, want error", b[0], b[1])
+		}
+	}
+}
+
+func TestSimulatePair_gcBias(t *testing.T) {
+	c, err := NewGCCurve("table", []float64{0.4, 0.6}, []float64{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{GCBias: c}
+
+	// Fragments from the GC half are always rejected.
+	at := bytes.Repeat([]byte("AT"), 2000)
+	gc := bytes.Repeat([]byte("GC"), 2000)
+	seq := append(append([]byte{}, at...), gc...)
+	m := PerfectModel
+	rng := rand.New(rand.NewPCG(1, 0))
+	for range 1000 {
+		p := m.SimulatePair(seq, rng, opts)
+		if start := min(p.Fwd.Start, p.Bwd.Start); start >= len(at) {
+			t.Fatalf("SimulatePair fragment starts at %d, want below %d",
+				start, len(at))
+		}
+		r := m.SimulateSingle(seq, rng, opts)
+		if r.Start >= len(at) {
+			t.Fatalf("SimulateSingle read starts at %d, want below %d",
+				r.Start, len(at))
+		}
+	}
+	// Nothing to accept.
+	if p := m.SimulatePairCircular(gc, rng, opts); p == nil {
+		t.Fatalf("SimulatePairCircular(GC only)=nil, want pair")
+	}
+}
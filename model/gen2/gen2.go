@@ -8,7 +8,6 @@ import (
 	"os"
 
 	"github.com/fluhus/gostuff/jio"
-	"github.com/fluhus/izzy/cdf"
 	"github.com/fluhus/izzy/model"
 )
 
@@ -23,6 +22,7 @@ func main() {
 		writeModelVar(name, buf)
 	}
 	b := bytes.ReplaceAll(buf.Bytes(), []byte("model.Model"), []byte("Model"))
+	b = bytes.ReplaceAll(b, []byte("model.samplers"), []byte("samplers"))
 	src, err := format.Source(b)
 	if err != nil {
 		panic(err)
@@ -38,36 +38,8 @@ func writeModelVar(name string, buf *bytes.Buffer) {
 	if err := jio.Read("gen2/"+name+".json", m); err != nil {
 		panic(err)
 	}
-	checkModelCDFs(m)
-	fmt.Fprintf(buf, "var %sModel = %#v\n", name, m)
-}
-
-func checkModelCDFs(m *model.Model) {
-	m.InsertLen.Check()
-	m.MeanCountForward.Check()
-	m.MeanCountReverse.Check()
-	checkCDFss(m.QualityHistForward)
-	checkCDFss(m.QualityHistReverse)
-	checkCDFs4(m.SubstChoicesForward)
-	checkCDFs4(m.SubstChoicesReverse)
-}
-
-func checkCDFs(cc []cdf.CDF) {
-	for _, c := range cc {
-		c.Check()
-	}
-}
-
-func checkCDFss(cc [][]cdf.CDF) {
-	for _, c := range cc {
-		checkCDFs(c)
-	}
-}
-
-func checkCDFs4(ccc [][4]cdf.CDF) {
-	for _, cc := range ccc {
-		for _, c := range cc {
-			c.Check()
-		}
+	if err := m.Validate(); err != nil {
+		panic(fmt.Sprintf("model %q: %v", name, err))
 	}
+	fmt.Fprintf(buf, "var %sModel = %#v\n", name, m)
 }
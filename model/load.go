@@ -0,0 +1,147 @@
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fluhus/gostuff/jio"
+	"github.com/fluhus/izzy/cdf"
+)
+
+// Load reads a model from a JSON file, in the same format as the built-in
+// models' source files. If the model has no name, the file name is used.
+// Returns an error if the model is malformed.
+func Load(file string) (*Model, error) {
+	m := &Model{}
+	if err := jio.Read(file, m); err != nil {
+		return nil, err
+	}
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(filepath.Base(file), ".json")
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("model %q: %w", m.Name, err)
+	}
+	m.Init()
+	return m, nil
+}
+
+// Validate checks that the model is well-formed, so that simulation will
+// not panic or go out of bounds. Returns an error naming the first bad
+// field and index.
+func (m *Model) Validate() error {
+	if m.ReadLen <= 0 {
+		return fmt.Errorf("ReadLen: bad read length: %d", m.ReadLen)
+	}
+	if err := m.InsertLen.Validate(); err != nil {
+		return fmt.Errorf("InsertLen: %w", err)
+	}
+	for _, dir := range []struct {
+		name  string
+		mean  cdf.CDF
+		qual  [][]cdf.CDF
+		subst [][4]cdf.CDF
+		ins   [][4]float64
+		del   [][4]float64
+	}{
+		{"Forward", m.MeanCountForward, m.QualityHistForward,
+			m.SubstChoicesForward, m.InsForward, m.DelForward},
+		{"Reverse", m.MeanCountReverse, m.QualityHistReverse,
+			m.SubstChoicesReverse, m.InsReverse, m.DelReverse},
+	} {
+		if err := dir.mean.Validate(); err != nil {
+			return fmt.Errorf("MeanCount%s: %w", dir.name, err)
+		}
+		if err := validateQuals(dir.qual, dir.mean, m.ReadLen); err != nil {
+			return fmt.Errorf("QualityHist%s%w", dir.name, err)
+		}
+		if err := validateSubst(dir.subst, m.ReadLen); err != nil {
+			return fmt.Errorf("SubstChoices%s%w", dir.name, err)
+		}
+		if err := validateProbs(dir.ins, m.ReadLen); err != nil {
+			return fmt.Errorf("Ins%s%w", dir.name, err)
+		}
+		if err := validateProbs(dir.del, m.ReadLen); err != nil {
+			return fmt.Errorf("Del%s%w", dir.name, err)
+		}
+	}
+	return nil
+}
+
+// Checks quality histograms against their mean-quality bins.
+// Errors start with the bad index, to follow the field name.
+func validateQuals(qual [][]cdf.CDF, mean cdf.CDF, readLen int) error {
+	if len(qual) != len(mean) {
+		return fmt.Errorf(": has %d bins, want %d (one per mean bin)",
+			len(qual), len(mean))
+	}
+	for i, h := range qual {
+		if len(h) == 0 {
+			if binProb(mean, i) > 0 {
+				return fmt.Errorf("[%d]: empty but has probability %f",
+					i, binProb(mean, i))
+			}
+			continue // Empty bins are never chosen.
+		}
+		if len(h) != readLen {
+			return fmt.Errorf("[%d]: has length %d, want %d",
+				i, len(h), readLen)
+		}
+		for j, c := range h {
+			if err := c.Validate(); err != nil {
+				return fmt.Errorf("[%d][%d]: %w", i, j, err)
+			}
+			if len(c) > len(phredToProb) {
+				return fmt.Errorf("[%d][%d]: has phred values up to %d, "+
+					"want at most %d", i, j, len(c)-1, len(phredToProb)-1)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the probability of the i'th element of a CDF.
+func binProb(c cdf.CDF, i int) float64 {
+	if i == 0 {
+		return c[0]
+	}
+	return c[i] - c[i-1]
+}
+
+// Checks per-position substitution CDFs.
+// Errors start with the bad index, to follow the field name.
+func validateSubst(subst [][4]cdf.CDF, readLen int) error {
+	if len(subst) != readLen {
+		return fmt.Errorf(": has length %d, want %d", len(subst), readLen)
+	}
+	for i, cc := range subst {
+		for j, c := range cc {
+			if err := c.Validate(); err != nil {
+				return fmt.Errorf("[%d][%d]: %w", i, j, err)
+			}
+			if len(c) != 4 {
+				return fmt.Errorf("[%d][%d]: has length %d, want 4",
+					i, j, len(c))
+			}
+		}
+	}
+	return nil
+}
+
+// Checks per-position probabilities.
+// Errors start with the bad index, to follow the field name.
+func validateProbs(probs [][4]float64, readLen int) error {
+	if len(probs) != readLen {
+		return fmt.Errorf(": has length %d, want %d", len(probs), readLen)
+	}
+	for i, pp := range probs {
+		for j, p := range pp {
+			if !(p >= 0 && p <= 1) { // Also catches NaN.
+				return fmt.Errorf("[%d][%d]: %f is not a probability",
+					i, j, p)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fluhus/izzy/cdf"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		file string
+		want *Model
+	}{
+		{"gen2/Basic.json", BasicModel},
+		{"gen2/HiSeq.json", HiSeqModel},
+	}
+	for _, test := range tests {
+		got, err := Load(test.file)
+		if err != nil {
+			t.Fatalf("Load(%q) failed: %v", test.file, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Load(%q) does not match the built-in model", test.file)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, m := range []*Model{BasicModel, PerfectModel, HiSeqModel,
+		MiSeqModel, NovaSeqModel} {
+		if err := m.Validate(); err != nil {
+			t.Errorf("%s.Validate() failed: %v", m.Name, err)
+		}
+	}
+
+	tests := []struct {
+		edit func(m *Model)
+		want string
+	}{
+		{func(m *Model) { m.ReadLen = 0 }, "ReadLen: "},
+		{func(m *Model) { m.InsertLen = cdf.CDF{0.5} }, "InsertLen: "},
+		{func(m *Model) { m.MeanCountReverse = nil }, "MeanCountReverse: "},
+		{func(m *Model) {
+			m.QualityHistForward = m.QualityHistForward[:3]
+		}, "QualityHistForward: "},
+		{func(m *Model) {
+			m.QualityHistForward[3] = nil
+		}, "QualityHistForward[3]: "},
+		{func(m *Model) {
+			m.QualityHistForward[3] = m.QualityHistForward[3][:10]
+		}, "QualityHistForward[3]: "},
+		{func(m *Model) {
+			m.QualityHistReverse[2][5] = cdf.CDF{0.5, 0.4, 1}
+		}, "QualityHistReverse[2][5]: "},
+		{func(m *Model) {
+			m.QualityHistForward[3][0] = make(cdf.CDF, 101)
+			m.QualityHistForward[3][0][100] = 1
+		}, "QualityHistForward[3][0]: "},
+		{func(m *Model) {
+			m.SubstChoicesReverse[7][2] = cdf.CDF{0.2, 0.1, 0.5, 1}
+		}, "SubstChoicesReverse[7][2]: "},
+		{func(m *Model) {
+			m.SubstChoicesForward[0][0] = cdf.CDF{1}
+		}, "SubstChoicesForward[0][0]: "},
+		{func(m *Model) { m.InsForward[3][1] = 1.5 }, "InsForward[3][1]: "},
+		{func(m *Model) { m.DelReverse = m.DelReverse[1:] }, "DelReverse: "},
+	}
+	for _, test := range tests {
+		m := cloneModel(HiSeqModel)
+		test.edit(m)
+		err := m.Validate()
+		if err == nil {
+			t.Errorf("Validate()=nil, want error starting with %q", test.want)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("Validate()=%q, want error starting with %q",
+				err, test.want)
+		}
+	}
+}
+
+// Returns a copy of a model, whose slices can be modified without
+// affecting the original, except for the innermost CDFs.
+func cloneModel(m *Model) *Model {
+	c := *m
+	c.QualityHistForward = slices.Clone(c.QualityHistForward)
+	c.QualityHistReverse = slices.Clone(c.QualityHistReverse)
+	for i := range c.QualityHistForward {
+		c.QualityHistForward[i] = slices.Clone(c.QualityHistForward[i])
+	}
+	for i := range c.QualityHistReverse {
+		c.QualityHistReverse[i] = slices.Clone(c.QualityHistReverse[i])
+	}
+	c.SubstChoicesForward = slices.Clone(c.SubstChoicesForward)
+	c.SubstChoicesReverse = slices.Clone(c.SubstChoicesReverse)
+	c.InsForward = slices.Clone(c.InsForward)
+	c.InsReverse = slices.Clone(c.InsReverse)
+	c.DelForward = slices.Clone(c.DelForward)
+	c.DelReverse = slices.Clone(c.DelReverse)
+	return &c
+}
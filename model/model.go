@@ -2,6 +2,7 @@
 package model
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"math/rand/v2"
@@ -23,7 +24,19 @@ const (
 	originalIndel = false
 )
 
+// Options are optional settings for simulating reads.
+// The zero value simulates without any of them.
+type Options struct {
+	GCBias *GCCurve // Nil for no GC bias
+
+	// Use the forward indel profiles for R2. This does not reproduce
+	// earlier versions' samples, which also had R1 on the plus strand only.
+	R2ForwardIndels bool
+}
+
 // Model holds probabilities for randomizing reads.
+// Init should be called before simulating with a model, and again after
+// changing its CDFs. Load and the built-in models call it.
 type Model struct {
 	Name                string
 	ReadLen             int
@@ -38,56 +51,122 @@ type Model struct {
 	InsReverse          [][4]float64
 	DelForward          [][4]float64
 	DelReverse          [][4]float64
+
+	s *samplers // Built by Init
+}
+
+// Alias samplers for a model's CDFs.
+type samplers struct {
+	insertLen           *cdf.Alias
+	meanCountForward    *cdf.Alias
+	meanCountReverse    *cdf.Alias
+	qualityHistForward  [][]*cdf.Alias
+	qualityHistReverse  [][]*cdf.Alias
+	substChoicesForward [][4]*cdf.Alias
+	substChoicesReverse [][4]*cdf.Alias
+}
+
+func init() {
+	for _, m := range []*Model{BasicModel, PerfectModel, HiSeqModel,
+		MiSeqModel, NovaSeqModel} {
+		m.Init()
+	}
+}
+
+// Init prepares m for simulation by building samplers for its CDFs.
+func (m *Model) Init() {
+	m.s = newSamplers(m)
+}
+
+// Returns the alias samplers of m's CDFs.
+func (m *Model) samplers() *samplers {
+	if m.s == nil {
+		panic("model: Init was not called")
+	}
+	return m.s
+}
+
+// Builds alias samplers for the CDFs of m.
+func newSamplers(m *Model) *samplers {
+	aliasss := func(cc [][]cdf.CDF) [][]*cdf.Alias {
+		return snm.SliceToSlice(cc, func(c []cdf.CDF) []*cdf.Alias {
+			return snm.SliceToSlice(c, cdf.CDF.Alias)
+		})
+	}
+	aliases4 := func(cc [][4]cdf.CDF) [][4]*cdf.Alias {
+		return snm.SliceToSlice(cc, func(c [4]cdf.CDF) [4]*cdf.Alias {
+			return [4]*cdf.Alias{
+				c[0].Alias(), c[1].Alias(), c[2].Alias(), c[3].Alias()}
+		})
+	}
+	return &samplers{
+		insertLen:           m.InsertLen.Alias(),
+		meanCountForward:    m.MeanCountForward.Alias(),
+		meanCountReverse:    m.MeanCountReverse.Alias(),
+		qualityHistForward:  aliasss(m.QualityHistForward),
+		qualityHistReverse:  aliasss(m.QualityHistReverse),
+		substChoicesForward: aliases4(m.SubstChoicesForward),
+		substChoicesReverse: aliases4(m.SubstChoicesReverse),
+	}
 }
 
 // Returns a slice of ReadLen random phred scores.
 func (m *Model) genPhredScores(forward bool, rng *rand.Rand) []int {
-	mean := m.MeanCountForward
+	s := m.samplers()
+	mean := s.meanCountForward
 	if !forward {
-		mean = m.MeanCountReverse
+		mean = s.meanCountReverse
 	}
 	qbin := mean.Choose(rng)
 
-	cdfss := m.QualityHistForward
+	aliasss := s.qualityHistForward
 	if !forward {
-		cdfss = m.QualityHistReverse
+		aliasss = s.qualityHistReverse
 	}
-	cdfs := cdfss[qbin]
+	aliases := aliasss[qbin]
 
 	return snm.Slice(m.ReadLen, func(i int) int {
-		return cdfs[i].Choose(rng)
+		return aliases[i].Choose(rng)
 	})
 }
 
 // Returns a random insert size between the forward read and its reverse
 // end.
 func (m *Model) randomInsertSize(rng *rand.Rand) int {
-	return m.InsertLen.Choose(rng)
+	return m.samplers().insertLen.Choose(rng)
 }
 
-// Applies indels to seq and returns the new sequence.
+// Applies indels to seq and returns the new sequence, along with its
+// alignment operations against seq.
 func (m *Model) introduceIndels(seq []byte, forward bool, rng *rand.Rand,
-) []byte {
+) ([]byte, []byte) {
 	ins, del := m.InsForward, m.DelForward
 	if !forward {
 		ins, del = m.InsReverse, m.DelReverse
 	}
 	result := make([]byte, 0, len(seq)*11/10)
+	ops := make([]byte, 0, len(seq)*11/10)
 	if !originalIndel {
 		// BUG(amit): In ISS i runs up to len-1, not sure why.
 		for i, b := range seq {
 			// Deletion - skip if rand < p.
 			ntoi := sequtil.Ntoi(b)
-			if ntoi == -1 {
+			if ntoi == -1 { // Ambiguous bases are kept as they are.
+				result = append(result, b)
+				ops = append(ops, opMatch)
 				continue
 			}
 			if rng.Float64() > del[i][ntoi] {
 				result = append(result, b)
+				ops = append(ops, opMatch)
+			} else {
+				ops = append(ops, opDel)
 			}
 			// BUG(amit): Not sure about this insertion logic. Taken from ISS.
 			for ii, p := range ins[i] {
 				if rng.Float64() < p {
 					result = append(result, sequtil.Iton(ii))
+					ops = append(ops, opIns)
 				}
 			}
 		}
@@ -121,16 +200,18 @@ func (m *Model) introduceIndels(seq []byte, forward bool, rng *rand.Rand,
 			}
 			pos++
 		}
+		// Alignment is not tracked in the original logic.
+		ops = bytes.Repeat([]byte{opMatch}, len(result))
 	}
-	return result
+	return result, ops
 }
 
 // Applies SNPs to seq according to the given phred scores.
 func (m *Model) introduceSNPs(seq []byte, phreds []int, forward bool,
 	rng *rand.Rand) {
-	subst := m.SubstChoicesForward
+	subst := m.samplers().substChoicesForward
 	if !forward {
-		subst = m.SubstChoicesReverse
+		subst = m.samplers().substChoicesReverse
 	}
 	for i := range seq {
 		p := phredToProb[phreds[i]]
@@ -139,77 +220,376 @@ func (m *Model) introduceSNPs(seq []byte, phreds []int, forward bool,
 			if ntoi == -1 {
 				continue
 			}
-			cdf := subst[i][ntoi]
-			seq[i] = sequtil.Iton(cdf.Choose(rng))
+			seq[i] = sequtil.Iton(subst[i][ntoi].Choose(rng))
 		}
 	}
 }
 
+// Alignment operations of a read against its source sequence.
+const (
+	opMatch = 'M' // Read base aligned to a source base
+	opIns   = 'I' // Read base not in the source
+	opDel   = 'D' // Source base not in the read
+)
+
+// Read is a simulated read along with its origin in the source sequence.
+type Read struct {
+	*fastq.Fastq
+
+	// Span on the source sequence, 0-based, end exclusive.
+	Start, End int
+
+	// Alignment operations against the source, in read orientation.
+	// One of 'M', 'I' or 'D' per base.
+	Ops []byte
+
+	// Number of errors introduced in this read.
+	Subst, Ins, Del int
+
+	// Whether the read comes from the minus strand of the source.
+	Reverse bool
+}
+
+// Pair is a simulated read pair along with its origin in the source
+// sequence.
+type Pair struct {
+	Fwd, Bwd    *Read // R1 and R2, either one can be on the minus strand
+	FragmentLen int   // Length of the source fragment
+}
+
 // SimulateRead randomizes a pair of reads from seq.
 // Returns nil of seq is too short.
 func (m *Model) SimulateRead(seq []byte, rng *rand.Rand,
 ) (*fastq.Fastq, *fastq.Fastq) {
-	if len(seq) < 2*m.ReadLen {
+	p := m.SimulatePair(seq, rng, Options{})
+	if p == nil {
 		return nil, nil
 	}
-	intervalLen := 2*m.ReadLen + m.randomInsertSize(rng)
-	// BUG(amit): Check if this is the best thing to do in this case.
-	intervalLen = min(intervalLen, len(seq))
-	i := rng.IntN(len(seq) - intervalLen + 1)
-	bwdStart := i + intervalLen - m.ReadLen
-	fwd := slices.Clone(seq[i : i+m.ReadLen])
-	bwd := sequtil.ReverseComplement(nil, seq[bwdStart:bwdStart+m.ReadLen])
+	return p.Fwd.Fastq, p.Bwd.Fastq
+}
+
+// SimulatePair randomizes a pair of reads from seq, keeping track of
+// their origin. The fragment comes from either strand with equal
+// probability, so R1 is on the minus strand half of the time.
+// Returns nil of seq is too short.
+func (m *Model) SimulatePair(seq []byte, rng *rand.Rand,
+	opts Options) *Pair {
+	if len(seq) < 2*m.ReadLen {
+		return nil
+	}
+	var i, intervalLen int
+	for try := 1; ; try++ {
+		intervalLen = 2*m.ReadLen + m.randomInsertSize(rng)
+		// BUG(amit): Check if this is the best thing to do in this case.
+		intervalLen = min(intervalLen, len(seq))
+		i = rng.IntN(len(seq) - intervalLen + 1)
+		if try == maxGCTries || opts.GCBias.keep(rng, seq[i:i+intervalLen]) {
+			break
+		}
+	}
+	return m.simulatePairAt(seq, i, intervalLen, rng, opts)
+}
 
-	fwd = m.introduceIndels(fwd, true, rng)
-	if len(fwd) > m.ReadLen {
-		fwd = fwd[:m.ReadLen]
+// SimulatePairCircular is like SimulatePair, but treats seq as circular, so
+// that fragments can span its end and continue from its start. Positions
+// of such fragments continue past len(seq), so that End, and Start of the
+// right read, can exceed it. Read names are positions within seq.
+func (m *Model) SimulatePairCircular(seq []byte, rng *rand.Rand,
+	opts Options) *Pair {
+	if len(seq) < 2*m.ReadLen {
+		return nil
 	}
-	if len(fwd) < m.ReadLen {
-		d := m.ReadLen - len(fwd)
-		fwd = append(fwd, seq[i+m.ReadLen:i+m.ReadLen+d]...)
+	var i, intervalLen int
+	for try := 1; ; try++ {
+		intervalLen = min(2*m.ReadLen+m.randomInsertSize(rng), len(seq))
+		i = rng.IntN(len(seq))
+		if try == maxGCTries ||
+			opts.GCBias.keep(rng, circularParts(seq, i, intervalLen)...) {
+			break
+		}
 	}
+	p := m.simulatePairAt(unroll(seq, i+intervalLen), i, intervalLen, rng,
+		opts)
+	p.Fwd.wrapName(len(seq))
+	p.Bwd.wrapName(len(seq))
+	return p
+}
+
+// Randomizes a pair of reads from the fragment of the given length that
+// starts at i.
+func (m *Model) simulatePairAt(seq []byte, i, intervalLen int, rng *rand.Rand,
+	opts Options) *Pair {
+	reverse := rng.IntN(2) == 1 // R1 is the right read.
+
+	// The left read is on the plus strand and the right read is on the
+	// minus strand, regardless of which one is R1.
+	rightStart := i + intervalLen - m.ReadLen
+	left := slices.Clone(seq[i : i+m.ReadLen])
+	right := sequtil.ReverseComplement(nil, seq[rightStart:rightStart+m.ReadLen])
 
-	bwd = m.introduceIndels(bwd, true, rng)
-	if len(bwd) > m.ReadLen {
-		bwd = bwd[:m.ReadLen]
+	// Each mate gets its own indel profile, unless R2 uses the forward one.
+	left, leftOps := m.introduceIndels(left, !reverse || opts.R2ForwardIndels,
+		rng)
+	if len(left) > m.ReadLen {
+		left = left[:m.ReadLen]
 	}
-	if len(bwd) < m.ReadLen {
+	if len(left) < m.ReadLen {
+		d := m.ReadLen - len(left)
+		left = append(left, seq[i+m.ReadLen:i+m.ReadLen+d]...)
+		leftOps = append(leftOps, bytes.Repeat([]byte{opMatch}, d)...)
+	}
+
+	right, rightOps := m.introduceIndels(right,
+		reverse || opts.R2ForwardIndels, rng)
+	if len(right) > m.ReadLen {
+		right = right[:m.ReadLen]
+	}
+	if len(right) < m.ReadLen {
 		if !originalIndel {
-			d := m.ReadLen - len(bwd)
-			bwd = sequtil.ReverseComplement(bwd, seq[bwdStart-d:bwdStart])
-			bwdStart -= d
+			d := m.ReadLen - len(right)
+			right = sequtil.ReverseComplement(right, seq[rightStart-d:rightStart])
+			rightOps = append(rightOps, bytes.Repeat([]byte{opMatch}, d)...)
 		} else {
-			d := m.ReadLen - len(bwd)
+			d := m.ReadLen - len(right)
 			for i := 0; i < d; i++ {
 				ii := i + intervalLen
 				if ii >= len(seq) {
-					bwd = append(bwd, 'A')
+					right = append(right, 'A')
 				} else {
-					bwd = sequtil.ReverseComplement(bwd, seq[ii:ii+1])
+					right = sequtil.ReverseComplement(right, seq[ii:ii+1])
 				}
 			}
+			rightOps = append(rightOps, bytes.Repeat([]byte{opMatch}, d)...)
 		}
 	}
 
-	fwdQuals := m.genPhredScores(true, rng)
-	bwdQuals := m.genPhredScores(false, rng)
+	// Quality and substitution profiles follow the mate number.
+	leftQuals := m.genPhredScores(!reverse, rng)
+	rightQuals := m.genPhredScores(reverse, rng)
 
-	m.introduceSNPs(fwd, fwdQuals, true, rng)
-	m.introduceSNPs(bwd, bwdQuals, false, rng)
+	m.introduceSNPs(left, leftQuals, !reverse, rng)
+	m.introduceSNPs(right, rightQuals, reverse, rng)
+
+	leftOps, lead := trimOps(leftOps, m.ReadLen)
+	leftr := &Read{Start: i + lead, Ops: leftOps}
+	leftr.End = leftr.Start + refLen(leftOps)
+	leftr.Subst = countSubst(left, seq[leftr.Start:leftr.End], leftOps)
+
+	// The right read starts at the end of the interval.
+	rightOps, lead = trimOps(rightOps, m.ReadLen)
+	rightr := &Read{End: i + intervalLen - lead, Ops: rightOps, Reverse: true}
+	rightr.Start = rightr.End - refLen(rightOps)
+	rightr.Subst = countSubst(right, sequtil.ReverseComplement(
+		nil, seq[rightr.Start:rightr.End]), rightOps)
 
 	// +1 to convert positions to 1-based.
-	fwdq := &fastq.Fastq{
-		Name:     fmt.Append(nil, i+1),
-		Sequence: fwd,
-		Quals:    phredsToASCII(fwdQuals),
+	leftr.Fastq = &fastq.Fastq{
+		Name:     fmt.Append(nil, leftr.Start+1),
+		Sequence: left,
+		Quals:    phredsToASCII(leftQuals),
+	}
+	rightr.Fastq = &fastq.Fastq{
+		Name:     fmt.Append(nil, rightr.Start+1),
+		Sequence: right,
+		Quals:    phredsToASCII(rightQuals),
 	}
-	bwdq := &fastq.Fastq{
-		Name:     fmt.Append(nil, bwdStart+1),
-		Sequence: bwd,
-		Quals:    phredsToASCII(bwdQuals),
+	leftr.Ins, leftr.Del = countIndels(leftOps)
+	rightr.Ins, rightr.Del = countIndels(rightOps)
+
+	if reverse {
+		return &Pair{rightr, leftr, intervalLen}
+	}
+	return &Pair{leftr, rightr, intervalLen}
+}
+
+// SimulateSingle randomizes a single-end read from either strand of seq,
+// using the forward profiles. Returns nil if seq is too short.
+func (m *Model) SimulateSingle(seq []byte, rng *rand.Rand,
+	opts Options) *Read {
+	if len(seq) < m.ReadLen {
+		return nil
+	}
+	for try := 1; ; try++ {
+		i := rng.IntN(len(seq) - m.ReadLen + 1)
+		reverse := rng.IntN(2) == 1
+		if try < maxGCTries && !opts.GCBias.keep(rng, seq[i:i+m.ReadLen]) {
+			continue
+		}
+		return m.simulateSingleAt(seq, i, reverse, rng)
+	}
+}
+
+// SimulateSingleCircular is like SimulateSingle, but treats seq as
+// circular, so that reads can span its end and continue from its start.
+// Start is always within seq, while End can exceed len(seq).
+func (m *Model) SimulateSingleCircular(seq []byte, rng *rand.Rand,
+	opts Options) *Read {
+	if len(seq) < m.ReadLen {
+		return nil
 	}
+	for try := 1; ; try++ {
+		i := rng.IntN(len(seq))
+		reverse := rng.IntN(2) == 1
+		if try < maxGCTries &&
+			!opts.GCBias.keep(rng, circularParts(seq, i, m.ReadLen)...) {
+			continue
+		}
+		// Leave room on both sides for filling in deleted bases.
+		offset := 0
+		if reverse && i < m.ReadLen {
+			offset = len(seq) * ((m.ReadLen-i-1)/len(seq) + 1)
+		}
+		useq := unroll(seq, offset+i+2*m.ReadLen)
+		r := m.simulateSingleAt(useq, offset+i, reverse, rng)
+		if d := r.Start / len(seq) * len(seq); d > 0 {
+			r.Start -= d
+			r.End -= d
+		}
+		r.wrapName(len(seq))
+		return r
+	}
+}
 
-	return fwdq, bwdq
+// Randomizes a single-end read from the given strand of seq, starting at
+// i. If deletions near the edge of seq leave too few bases to fill in,
+// the read is truncated there.
+func (m *Model) simulateSingleAt(seq []byte, i int, reverse bool,
+	rng *rand.Rand) *Read {
+	var read []byte
+	if reverse {
+		read = sequtil.ReverseComplement(nil, seq[i:i+m.ReadLen])
+	} else {
+		read = slices.Clone(seq[i : i+m.ReadLen])
+	}
+
+	read, ops := m.introduceIndels(read, true, rng)
+	if len(read) > m.ReadLen {
+		read = read[:m.ReadLen]
+	}
+	if d := m.ReadLen - len(read); d > 0 {
+		// Fill in from the sequence that follows, in read orientation.
+		if reverse {
+			d = min(d, i)
+			read = sequtil.ReverseComplement(read, seq[i-d:i])
+		} else {
+			d = min(d, len(seq)-i-m.ReadLen)
+			read = append(read, seq[i+m.ReadLen:i+m.ReadLen+d]...)
+		}
+		ops = append(ops, bytes.Repeat([]byte{opMatch}, d)...)
+	}
+
+	quals := m.genPhredScores(true, rng)[:len(read)]
+	m.introduceSNPs(read, quals, true, rng)
+
+	ops, lead := trimOps(ops, m.ReadLen)
+	r := &Read{Ops: ops, Reverse: reverse}
+	if reverse {
+		r.End = i + m.ReadLen - lead
+		r.Start = r.End - refLen(ops)
+		r.Subst = countSubst(read, sequtil.ReverseComplement(
+			nil, seq[r.Start:r.End]), ops)
+	} else {
+		r.Start = i + lead
+		r.End = r.Start + refLen(ops)
+		r.Subst = countSubst(read, seq[r.Start:r.End], ops)
+	}
+	r.Ins, r.Del = countIndels(ops)
+	r.Fastq = &fastq.Fastq{
+		Name:     fmt.Append(nil, r.Start+1),
+		Sequence: read,
+		Quals:    phredsToASCII(quals),
+	}
+	return r
+}
+
+// Sets the read's name to its start position on a circular sequence of
+// length n.
+func (r *Read) wrapName(n int) {
+	r.Name = fmt.Append(r.Name[:0], r.Start%n+1)
+}
+
+// Returns seq repeated enough times to have at least n bases.
+// Returns seq itself if it is long enough.
+func unroll(seq []byte, n int) []byte {
+	if n <= len(seq) {
+		return seq
+	}
+	result := make([]byte, 0, n+len(seq))
+	for len(result) < n {
+		result = append(result, seq...)
+	}
+	return result
+}
+
+// Truncates ops to n read bases and removes deletions from both ends,
+// since they do not affect the read. Returns the trimmed ops and the
+// number of leading deletions that were removed.
+func trimOps(ops []byte, n int) ([]byte, int) {
+	lead := 0
+	for lead < len(ops) && ops[lead] == opDel {
+		lead++
+	}
+	ops = ops[lead:]
+	nread := 0
+	for i, op := range ops {
+		if op != opDel {
+			nread++
+		}
+		if nread == n {
+			ops = ops[:i+1]
+			break
+		}
+	}
+	// Truncated reads may end with deletions.
+	for len(ops) > 0 && ops[len(ops)-1] == opDel {
+		ops = ops[:len(ops)-1]
+	}
+	return ops, lead
+}
+
+// Returns the number of source bases covered by the given operations.
+func refLen(ops []byte) int {
+	n := 0
+	for _, op := range ops {
+		if op != opIns {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the number of aligned bases that differ between read and ref,
+// according to the given operations.
+func countSubst(read, ref, ops []byte) int {
+	n := 0
+	for _, op := range ops {
+		switch op {
+		case opMatch:
+			if sequtil.Ntoi(read[0]) != sequtil.Ntoi(ref[0]) {
+				n++
+			}
+			read, ref = read[1:], ref[1:]
+		case opIns:
+			read = read[1:]
+		case opDel:
+			ref = ref[1:]
+		}
+	}
+	return n
+}
+
+// Returns the number of insertions and deletions in the given operations.
+func countIndels(ops []byte) (int, int) {
+	ins, del := 0, 0
+	for _, op := range ops {
+		switch op {
+		case opIns:
+			ins++
+		case opDel:
+			del++
+		}
+	}
+	return ins, del
 }
 
 // Encodes the given phred scores as ASCII for text output.
@@ -2,8 +2,14 @@ package model
 
 import (
 	"bytes"
+	"fmt"
 	"math/rand/v2"
+	"slices"
 	"testing"
+
+	"github.com/fluhus/biostuff/sequtil"
+	"github.com/fluhus/gostuff/snm"
+	"github.com/fluhus/izzy/cdf"
 )
 
 func TestPerfectModel(t *testing.T) {
@@ -18,6 +24,9 @@ func TestPerfectModel(t *testing.T) {
 
 	for i := 0; i < 10; i++ {
 		r1, r2 := m.SimulateRead(seq, rng)
+		if r1.Sequence[0] == 'C' { // R1 is on the minus strand.
+			r1, r2 = r2, r1
+		}
 		if !bytes.Equal(wantFwd, r1.Sequence) {
 			t.Errorf("r1.Sequence=%q, want %q", r1.Sequence, wantFwd)
 		}
@@ -49,9 +58,13 @@ func TestPerfectModel_pos(t *testing.T) {
 	rng := rand.New(rand.NewPCG(0, 0))
 
 	for i := 0; i < 20; i++ {
-		r1, r2 := m.SimulateRead(seq, rng)
-		gotFwd[string(r1.Name)]++
-		gotBwd[string(r2.Name)]++
+		p := m.SimulatePair(seq, rng, Options{})
+		plus, minus := p.Fwd, p.Bwd
+		if plus.Reverse {
+			plus, minus = minus, plus
+		}
+		gotFwd[string(plus.Name)]++
+		gotBwd[string(minus.Name)]++
 	}
 	if !mapAtLeast(gotFwd, wantFwd) {
 		t.Errorf("pos count=%v, want at least %v", gotFwd, wantFwd)
@@ -61,6 +74,353 @@ func TestPerfectModel_pos(t *testing.T) {
 	}
 }
 
+func TestSimulatePair_alignment(t *testing.T) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	seq := make([]byte, 2000)
+	for i := range seq {
+		seq[i] = "ACGT"[rng.IntN(4)]
+	}
+	for _, m := range []*Model{NovaSeqModel, MiSeqModel, HiSeqModel} {
+		nindels := 0
+		for range 1000 {
+			p := m.SimulatePair(seq, rng, Options{})
+			left, right := p.Fwd, p.Bwd
+			if left.Reverse {
+				left, right = right, left
+			}
+			if left.Reverse || !right.Reverse {
+				t.Fatalf("%s: both mates are on the same strand", m.Name)
+			}
+			if left.Start < 0 || right.End > len(seq) ||
+				right.End-left.Start != p.FragmentLen {
+				t.Fatalf("%s: bad span: %d-%d, fragment length %d",
+					m.Name, left.Start, right.End, p.FragmentLen)
+			}
+			for _, r := range []*Read{p.Fwd, p.Bwd} {
+				if len(r.Sequence) != m.ReadLen {
+					t.Fatalf("%s: len(Sequence)=%d, want %d",
+						m.Name, len(r.Sequence), m.ReadLen)
+				}
+				if got := len(r.Ops) - r.Del; got != m.ReadLen {
+					t.Fatalf("%s: read bases in ops=%d, want %d",
+						m.Name, got, m.ReadLen)
+				}
+				if got := len(r.Ops) - r.Ins; got != r.End-r.Start {
+					t.Fatalf("%s: ref bases in ops=%d, want %d",
+						m.Name, got, r.End-r.Start)
+				}
+				nindels += r.Ins + r.Del
+			}
+		}
+		if nindels == 0 {
+			t.Errorf("%s: got no indels", m.Name)
+		}
+	}
+}
+
+func TestSimulatePair_strand(t *testing.T) {
+	// Forward qualities are all 40 and reverse ones are all 2, so mates
+	// can be told apart by their profile.
+	m := cloneModel(PerfectModel)
+	m.QualityHistReverse = [][]cdf.CDF{snm.Slice(m.ReadLen, func(i int) cdf.CDF {
+		return cdf.CDF{0, 0, 1}
+	})}
+	m.Init()
+	wantR1 := bytes.Repeat([]byte{33 + 40}, m.ReadLen)
+	wantR2 := bytes.Repeat([]byte{33 + 2}, m.ReadLen)
+
+	rng := rand.New(rand.NewPCG(0, 0))
+	seq := bytes.Repeat([]byte("ACGTTGCA"), 100)
+	const n = 2000
+	nrev := 0
+	for range n {
+		p := m.SimulatePair(seq, rng, Options{})
+		if p.Fwd.Reverse == p.Bwd.Reverse {
+			t.Fatalf("both mates are on the same strand")
+		}
+		if p.Fwd.Reverse {
+			nrev++
+		}
+		if !bytes.Equal(p.Fwd.Quals, wantR1) {
+			t.Fatalf("R1 quals=%q, want %q", p.Fwd.Quals, wantR1)
+		}
+		if !bytes.Equal(p.Bwd.Quals, wantR2) {
+			t.Fatalf("R2 quals=%q, want %q", p.Bwd.Quals, wantR2)
+		}
+	}
+	// About 4.5 standard deviations.
+	if nrev < n/2-100 || nrev > n/2+100 {
+		t.Errorf("%d/%d pairs with R1 on the minus strand, want ~%d",
+			nrev, n, n/2)
+	}
+}
+
+func TestSimulatePair_indelProfiles(t *testing.T) {
+	m := cloneModel(PerfectModel)
+	const insFwd, delFwd, insRev, delRev = 0.002, 0.01, 0.008, 0.03
+	m.InsForward = snm.Slice(m.ReadLen, func(i int) [4]float64 {
+		return [4]float64{insFwd / 4, insFwd / 4, insFwd / 4, insFwd / 4}
+	})
+	m.InsReverse = snm.Slice(m.ReadLen, func(i int) [4]float64 {
+		return [4]float64{insRev / 4, insRev / 4, insRev / 4, insRev / 4}
+	})
+	m.DelForward = snm.Slice(m.ReadLen, func(i int) [4]float64 {
+		return [4]float64{delFwd, delFwd, delFwd, delFwd}
+	})
+	m.DelReverse = snm.Slice(m.ReadLen, func(i int) [4]float64 {
+		return [4]float64{delRev, delRev, delRev, delRev}
+	})
+
+	rng := rand.New(rand.NewPCG(0, 0))
+	seq := make([]byte, 1000)
+	for i := range seq {
+		seq[i] = "ACGT"[rng.IntN(4)]
+	}
+
+	// Returns the insertion and deletion rates per source base, of R1 and
+	// of R2.
+	rates := func(opts Options) (ins1, del1, ins2, del2 float64) {
+		var ins, del, bases [2]int
+		for range 5000 {
+			p := m.SimulatePair(seq, rng, opts)
+			for i, r := range []*Read{p.Fwd, p.Bwd} {
+				ins[i] += r.Ins
+				del[i] += r.Del
+				bases[i] += r.End - r.Start
+			}
+		}
+		return float64(ins[0]) / float64(bases[0]),
+			float64(del[0]) / float64(bases[0]),
+			float64(ins[1]) / float64(bases[1]),
+			float64(del[1]) / float64(bases[1])
+	}
+	near := func(got, want float64) bool {
+		return got > want*0.85 && got < want*1.15
+	}
+
+	ins1, del1, ins2, del2 := rates(Options{})
+	if !near(ins1, insFwd) || !near(del1, delFwd) {
+		t.Errorf("R1 rates: ins=%f del=%f, want ~%f ~%f",
+			ins1, del1, insFwd, delFwd)
+	}
+	if !near(ins2, insRev) || !near(del2, delRev) {
+		t.Errorf("R2 rates: ins=%f del=%f, want ~%f ~%f",
+			ins2, del2, insRev, delRev)
+	}
+
+	_, _, ins2, del2 = rates(Options{R2ForwardIndels: true})
+	if !near(ins2, insFwd) || !near(del2, delFwd) {
+		t.Errorf("R2 forward-profile rates: ins=%f del=%f, want ~%f ~%f",
+			ins2, del2, insFwd, delFwd)
+	}
+}
+
+func TestSimulatePair_keepsN(t *testing.T) {
+	m := PerfectModel
+	seq := bytes.Repeat([]byte("ACGTN"), 100)
+	rng := rand.New(rand.NewPCG(0, 0))
+	for range 100 {
+		p := m.SimulatePair(seq, rng, Options{})
+		for _, r := range []*Read{p.Fwd, p.Bwd} {
+			src := seq[r.Start:r.End]
+			if r.Reverse {
+				src = sequtil.ReverseComplement(nil, src)
+			}
+			if !bytes.Equal(r.Sequence, src) {
+				t.Fatalf("Sequence=%q, want %q", r.Sequence, src)
+			}
+		}
+	}
+}
+
+func TestSimulateSingle(t *testing.T) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	seq := make([]byte, 300)
+	for i := range seq {
+		seq[i] = "ACGT"[rng.IntN(4)]
+	}
+	const n = 2000
+	for _, m := range []*Model{PerfectModel, HiSeqModel} {
+		nrev := 0
+		for range n {
+			r := m.SimulateSingle(seq, rng, Options{})
+			// Reads may be truncated at the edges.
+			atEdge := r.Start == 0 || r.End == len(seq)
+			if len(r.Sequence) != m.ReadLen &&
+				!(atEdge && len(r.Sequence) < m.ReadLen) {
+				t.Fatalf("%s: len(Sequence)=%d, want %d",
+					m.Name, len(r.Sequence), m.ReadLen)
+			}
+			if len(r.Quals) != len(r.Sequence) {
+				t.Fatalf("%s: len(Quals)=%d, want %d",
+					m.Name, len(r.Quals), len(r.Sequence))
+			}
+			if r.Start < 0 || r.End > len(seq) ||
+				len(r.Ops)-r.Ins != r.End-r.Start {
+				t.Fatalf("%s: bad span: %d-%d, ops %q",
+					m.Name, r.Start, r.End, r.Ops)
+			}
+			src := seq[r.Start:r.End]
+			if r.Reverse {
+				nrev++
+				src = sequtil.ReverseComplement(nil, src)
+			}
+			if m == PerfectModel && !bytes.Equal(r.Sequence, src) {
+				t.Fatalf("%s: Sequence=%q, want %q", m.Name, r.Sequence, src)
+			}
+		}
+		// About 4.5 standard deviations.
+		if nrev < n/2-100 || nrev > n/2+100 {
+			t.Errorf("%s: %d/%d reverse reads, want ~%d", m.Name, nrev, n, n/2)
+		}
+	}
+	if r := PerfectModel.SimulateSingle(seq[:124], rng,
+		Options{}); r != nil {
+		t.Errorf("SimulateSingle(short)=%v, want nil", r)
+	}
+}
+
+// Checks that deletions near the edges do not bias the read starts.
+func TestSimulateSingle_ends(t *testing.T) {
+	m := cloneModel(PerfectModel)
+	const del = 0.02
+	m.DelForward = snm.Slice(m.ReadLen, func(i int) [4]float64 {
+		return [4]float64{del, del, del, del}
+	})
+	m.Init()
+
+	rng := rand.New(rand.NewPCG(0, 0))
+	seq := make([]byte, 3*m.ReadLen)
+	for i := range seq {
+		seq[i] = "ACGT"[rng.IntN(4)]
+	}
+	cov := make([]int, len(seq))
+	for range 100000 {
+		r := m.SimulateSingle(seq, rng, Options{})
+		for i := r.Start; i < r.End; i++ {
+			cov[i]++
+		}
+	}
+
+	// Without deletions, an edge base is covered by reads from one start
+	// on each strand, and a middle base by reads from ReadLen starts.
+	// Deletions only extend reads, so edges get more than that.
+	mid := float64(cov[len(seq)/2])
+	want := 2.0 / float64(m.ReadLen)
+	for _, i := range []int{0, len(seq) - 1} {
+		if got := float64(cov[i]) / mid; got < want {
+			t.Errorf("coverage[%d]/coverage[mid]=%f, want at least %f",
+				i, got, want)
+		}
+	}
+}
+
+func TestSimulatePairCircular(t *testing.T) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	seq := make([]byte, 400)
+	for i := range seq {
+		seq[i] = "ACGT"[rng.IntN(4)]
+	}
+	useq := append(slices.Clone(seq), seq...)
+	const n = 2000
+	for _, m := range []*Model{PerfectModel, HiSeqModel} {
+		nwrap := 0
+		for range n {
+			p := m.SimulatePairCircular(seq, rng, Options{})
+			left, right := p.Fwd, p.Bwd
+			if left.Reverse {
+				left, right = right, left
+			}
+			if left.Start >= len(seq) || right.End-left.Start != p.FragmentLen {
+				t.Fatalf("%s: bad span: %d-%d, fragment %d",
+					m.Name, left.Start, right.End, p.FragmentLen)
+			}
+			if right.End > len(seq) {
+				nwrap++
+			}
+			for _, r := range []*Read{p.Fwd, p.Bwd} {
+				if want := fmt.Sprint(r.Start%len(seq) + 1); string(r.Name) != want {
+					t.Fatalf("%s: Name=%q, want %q", m.Name, r.Name, want)
+				}
+				src := useq[r.Start:r.End]
+				if r.Reverse {
+					src = sequtil.ReverseComplement(nil, src)
+				}
+				if m == PerfectModel && !bytes.Equal(r.Sequence, src) {
+					t.Fatalf("%s: Sequence=%q, want %q", m.Name, r.Sequence, src)
+				}
+			}
+		}
+		if nwrap == 0 {
+			t.Errorf("%s: no fragments span the origin", m.Name)
+		}
+	}
+	if p := PerfectModel.SimulatePairCircular(seq[:249], rng,
+		Options{}); p != nil {
+		t.Errorf("SimulatePairCircular(short)=%v, want nil", p)
+	}
+}
+
+func TestSimulateSingleCircular(t *testing.T) {
+	rng := rand.New(rand.NewPCG(0, 0))
+	seq := make([]byte, 200)
+	for i := range seq {
+		seq[i] = "ACGT"[rng.IntN(4)]
+	}
+	useq := append(slices.Clone(seq), seq...)
+	const n = 4000
+	for _, m := range []*Model{PerfectModel, HiSeqModel} {
+		starts := make([]int, len(seq))
+		for range n {
+			r := m.SimulateSingleCircular(seq, rng, Options{})
+			if r.Start < 0 || r.Start >= len(seq) ||
+				len(r.Ops)-r.Ins != r.End-r.Start {
+				t.Fatalf("%s: bad span: %d-%d, ops %q",
+					m.Name, r.Start, r.End, r.Ops)
+			}
+			starts[r.Start]++
+			src := useq[r.Start:r.End]
+			if r.Reverse {
+				src = sequtil.ReverseComplement(nil, src)
+			}
+			if m == PerfectModel && !bytes.Equal(r.Sequence, src) {
+				t.Fatalf("%s: Sequence=%q, want %q", m.Name, r.Sequence, src)
+			}
+		}
+		// Reads start everywhere, including near the end.
+		if starts[len(seq)-1] == 0 {
+			t.Errorf("%s: no reads start at the last base", m.Name)
+		}
+	}
+}
+
+func BenchmarkPhredScores(b *testing.B) {
+	m := NovaSeqModel
+	rng := rand.New(rand.NewPCG(0, 0))
+	b.Run("CDF", func(b *testing.B) {
+		for range b.N {
+			qbin := m.MeanCountForward.Choose(rng)
+			for _, c := range m.QualityHistForward[qbin] {
+				c.Choose(rng)
+			}
+		}
+	})
+	b.Run("Alias", func(b *testing.B) {
+		for range b.N {
+			m.genPhredScores(true, rng)
+		}
+	})
+}
+
+func BenchmarkSimulateRead(b *testing.B) {
+	m := NovaSeqModel
+	seq := bytes.Repeat([]byte("ACGGT"), 1000)
+	rng := rand.New(rand.NewPCG(0, 0))
+	for range b.N {
+		m.SimulateRead(seq, rng)
+	}
+}
+
 func mapAtLeast(m1, m2 map[string]int) bool {
 	for k, v := range m2 {
 		if v > m1[k] {
@@ -69,3 +429,24 @@ func mapAtLeast(m1, m2 map[string]int) bool {
 	}
 	return true
 }
+
+func TestInit(t *testing.T) {
+	m := cloneModel(PerfectModel)
+	m.s = nil
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("SimulatePair(...) without Init did not panic")
+			}
+		}()
+		m.SimulatePair(make([]byte, 1000), rand.New(rand.NewPCG(0, 0)),
+			Options{})
+	}()
+
+	// Changed CDFs take effect after Init.
+	m.InsertLen = cdf.CDF{0, 0, 0, 1}
+	m.Init()
+	if got := m.randomInsertSize(rand.New(rand.NewPCG(0, 0))); got != 3 {
+		t.Errorf("randomInsertSize(...)=%d, want 3", got)
+	}
+}
@@ -0,0 +1,138 @@
+// Package spec parses specs of named things with numeric parameters, like
+// "lognormal(sigma=2.3)".
+package spec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Param is a numeric parameter of a spec.
+type Param struct {
+	Name  string
+	Value float64                // Default value
+	Check func(x float64) string // Returns an error message if x is bad
+}
+
+// Parameter checks.
+var (
+	Positive = func(x float64) string {
+		if x <= 0 {
+			return "should be positive"
+		}
+		return ""
+	}
+	NonNegative = func(x float64) string {
+		if x < 0 {
+			return "should be non-negative"
+		}
+		return ""
+	}
+	Probability = func(x float64) string {
+		if x < 0 || x >= 1 {
+			return "should be at least 0 and less than 1"
+		}
+		return ""
+	}
+	Fraction = func(x float64) string {
+		if x < 0 || x > 1 {
+			return "should be between 0 and 1"
+		}
+		return ""
+	}
+	PositiveInt = func(x float64) string {
+		if x < 1 || x != math.Trunc(x) || x > math.MaxInt32 {
+			return "should be a positive integer"
+		}
+		return ""
+	}
+)
+
+// Parse parses s, which is a name optionally followed by parameters in
+// parentheses, like "lognormal(sigma=2.3)". params has the parameters of
+// each valid name, and what describes the names in errors, like
+// "distribution". Returns the name and the values of all of its
+// parameters, where missing parameters get their default values.
+func Parse(s, what string, params map[string][]Param) (string,
+	map[string]float64, error) {
+	name, args, hasArgs := strings.Cut(strings.TrimSpace(s), "(")
+	name = strings.TrimSpace(name)
+	ps, ok := params[name]
+	if !ok {
+		names := maps.Keys(params)
+		slices.Sort(names)
+		return "", nil, fmt.Errorf("unknown %s: %q, need one of %v",
+			what, name, names)
+	}
+	values := map[string]float64{}
+	for _, p := range ps {
+		values[p.Name] = p.Value
+	}
+	if !hasArgs {
+		return name, values, nil
+	}
+
+	args, ok = strings.CutSuffix(strings.TrimSpace(args), ")")
+	if !ok {
+		return "", nil, fmt.Errorf("%s: missing closing parenthesis", name)
+	}
+	if strings.TrimSpace(args) == "" { // Empty parentheses.
+		return name, values, nil
+	}
+	seen := map[string]bool{}
+	for _, arg := range strings.Split(args, ",") {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("%s: parameter %q should be name=value",
+				name, strings.TrimSpace(arg))
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		i := slices.IndexFunc(ps, func(p Param) bool {
+			return p.Name == k
+		})
+		if i == -1 {
+			return "", nil, fmt.Errorf("%s: unknown parameter %q, "+
+				"need one of %v", name, k, Names(ps))
+		}
+		if seen[k] {
+			return "", nil, fmt.Errorf("%s: duplicate parameter %q", name, k)
+		}
+		seen[k] = true
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+			return "", nil, fmt.Errorf("%s: bad value for %s: %q", name, k, v)
+		}
+		if msg := ps[i].Check(x); msg != "" {
+			return "", nil, fmt.Errorf("%s: %s=%v %s", name, k, x, msg)
+		}
+		values[k] = x
+	}
+	return name, values, nil
+}
+
+// Format returns the spec of the given name and parameter values,
+// with the parameters in the order of ps.
+func Format(name string, ps []Param, values map[string]float64) string {
+	if len(ps) == 0 {
+		return name
+	}
+	var args []string
+	for _, p := range ps {
+		args = append(args, fmt.Sprintf("%s=%v", p.Name, values[p.Name]))
+	}
+	return name + "(" + strings.Join(args, ",") + ")"
+}
+
+// Names returns the names of the given parameters.
+func Names(ps []Param) []string {
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.Name)
+	}
+	return names
+}
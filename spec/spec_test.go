@@ -0,0 +1,54 @@
+package spec
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testParams = map[string][]Param{
+	"none": nil,
+	"two": {
+		{Name: "a", Value: 1, Check: Positive},
+		{Name: "b", Value: 0.5, Check: Fraction},
+	},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s          string
+		wantName   string
+		wantValues map[string]float64
+		wantFormat string
+	}{
+		{"none", "none", map[string]float64{}, "none"},
+		{"none()", "none", map[string]float64{}, "none"},
+		{"two", "two", map[string]float64{"a": 1, "b": 0.5}, "two(a=1,b=0.5)"},
+		{" two ( b = 1 , a=3) ", "two", map[string]float64{"a": 3, "b": 1},
+			"two(a=3,b=1)"},
+	}
+	for _, test := range tests {
+		name, values, err := Parse(test.s, "thing", testParams)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.s, err)
+		}
+		if name != test.wantName || !reflect.DeepEqual(values, test.wantValues) {
+			t.Errorf("Parse(%q)=%q,%v, want %q,%v", test.s, name, values,
+				test.wantName, test.wantValues)
+		}
+		if got := Format(name, testParams[name], values); got != test.wantFormat {
+			t.Errorf("Format(%q,...)=%q, want %q", name, got, test.wantFormat)
+		}
+	}
+}
+
+func TestParse_bad(t *testing.T) {
+	for _, s := range []string{
+		"", "foo", "two(", "two(a)", "two(c=1)", "two(a=0)", "two(b=2)",
+		"two(a=x)", "two(a=NaN)", "two(a=Inf)", "two(a=1,a=2)", "two(a=1,)",
+		"none(a=1)",
+	} {
+		if name, values, err := Parse(s, "thing", testParams); err == nil {
+			t.Errorf("Parse(%q)=%q,%v, want error", s, name, values)
+		}
+	}
+}